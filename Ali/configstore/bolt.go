@@ -0,0 +1,195 @@
+package configstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/hashicorp/consul/api"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+var kvBucket = []byte("kv")
+
+// boltKV stores pairs in a single bbolt bucket using the same keys as Consul.
+// Each value is prefixed with its modify and create index.
+type boltKV struct {
+	db *bolt.DB
+}
+
+func NewBolt(path string) (*ConfigStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(kvBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &ConfigStore{
+		kv: &boltKV{db: db},
+	}, nil
+}
+
+func (b *boltKV) Close() error {
+	return b.db.Close()
+}
+
+func encodePair(p *api.KVPair) []byte {
+	buf := make([]byte, 16+len(p.Value))
+	binary.BigEndian.PutUint64(buf[0:8], p.ModifyIndex)
+	binary.BigEndian.PutUint64(buf[8:16], p.CreateIndex)
+	copy(buf[16:], p.Value)
+	return buf
+}
+
+func decodePair(key []byte, raw []byte) *api.KVPair {
+	return &api.KVPair{
+		Key:         string(key),
+		ModifyIndex: binary.BigEndian.Uint64(raw[0:8]),
+		CreateIndex: binary.BigEndian.Uint64(raw[8:16]),
+		Value:       append([]byte(nil), raw[16:]...),
+	}
+}
+
+func (b *boltKV) Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	var pair *api.KVPair
+	err := b.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(kvBucket).Get([]byte(key))
+		if raw != nil {
+			pair = decodePair([]byte(key), raw)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return pair, &api.QueryMeta{}, nil
+}
+
+func (b *boltKV) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	pairs := api.KVPairs{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(kvBucket).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			pairs = append(pairs, decodePair(k, v))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return pairs, &api.QueryMeta{}, nil
+}
+
+func (b *boltKV) Keys(prefix string, separator string, q *api.QueryOptions) ([]string, *api.QueryMeta, error) {
+	keys := []string{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(kvBucket).Cursor()
+		p := []byte(prefix)
+		for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return keysUpTo(keys, prefix, separator), &api.QueryMeta{}, nil
+}
+
+func (b *boltKV) Put(p *api.KVPair, q *api.WriteOptions) (*api.WriteMeta, error) {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return b.put(tx.Bucket(kvBucket), p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.WriteMeta{}, nil
+}
+
+func (b *boltKV) put(bucket *bolt.Bucket, p *api.KVPair) error {
+	index, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	stored := &api.KVPair{Key: p.Key, Value: p.Value, ModifyIndex: index, CreateIndex: index}
+	if raw := bucket.Get([]byte(p.Key)); raw != nil {
+		stored.CreateIndex = decodePair([]byte(p.Key), raw).CreateIndex
+	}
+	return bucket.Put([]byte(p.Key), encodePair(stored))
+}
+
+func (b *boltKV) Delete(key string, w *api.WriteOptions) (*api.WriteMeta, error) {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(kvBucket).Delete([]byte(key))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.WriteMeta{}, nil
+}
+
+func (b *boltKV) DeleteTree(prefix string, w *api.WriteOptions) (*api.WriteMeta, error) {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(kvBucket).Cursor()
+		p := []byte(prefix)
+		for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Seek(p) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.WriteMeta{}, nil
+}
+
+func (b *boltKV) CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	ok := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(kvBucket)
+		if !b.matches(bucket, p) {
+			return nil
+		}
+		ok = true
+		return b.put(bucket, p)
+	})
+	if err != nil {
+		return false, nil, err
+	}
+	return ok, &api.WriteMeta{}, nil
+}
+
+func (b *boltKV) DeleteCAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	ok := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(kvBucket)
+		if p.ModifyIndex == 0 || !b.matches(bucket, p) {
+			return nil
+		}
+		ok = true
+		return bucket.Delete([]byte(p.Key))
+	})
+	if err != nil {
+		return false, nil, err
+	}
+	return ok, &api.WriteMeta{}, nil
+}
+
+// matches reports whether p.ModifyIndex is the current index of p.Key,
+// where index 0 means the key must not exist yet.
+func (b *boltKV) matches(bucket *bolt.Bucket, p *api.KVPair) bool {
+	raw := bucket.Get([]byte(p.Key))
+	if p.ModifyIndex == 0 {
+		return raw == nil
+	}
+	return raw != nil && decodePair([]byte(p.Key), raw).ModifyIndex == p.ModifyIndex
+}
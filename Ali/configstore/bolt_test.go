@@ -0,0 +1,168 @@
+package configstore
+
+import (
+	"errors"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/net/context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestBolt(t *testing.T) *ConfigStore {
+	t.Helper()
+	store, err := NewBolt(filepath.Join(t.TempDir(), "config.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// TestBackends runs the same key operations on every embedded backend and
+// expects the answers Consul gives.
+func TestBackends(t *testing.T) {
+	backends := []struct {
+		name  string
+		store func(t *testing.T) *ConfigStore
+	}{
+		{"memory", func(t *testing.T) *ConfigStore { return NewInMemory() }},
+		{"bolt", newTestBolt},
+	}
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			kv := b.store(t).kv
+			for _, key := range []string{"a/1", "a/2/x", "a/2/y", "b/1"} {
+				if _, err := kv.Put(&api.KVPair{Key: key, Value: []byte(key)}, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			pairs, _, err := kv.List("a/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			listed := []string{}
+			for _, p := range pairs {
+				listed = append(listed, p.Key)
+			}
+			if want := []string{"a/1", "a/2/x", "a/2/y"}; !reflect.DeepEqual(listed, want) {
+				t.Errorf("list got %v, want %v", listed, want)
+			}
+			keys, _, err := kv.Keys("a/", "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"a/1", "a/2/"}; !reflect.DeepEqual(keys, want) {
+				t.Errorf("keys got %v, want %v", keys, want)
+			}
+
+			pair, _, err := kv.Get("a/1", nil)
+			if err != nil || pair == nil {
+				t.Fatalf("get a/1: %v, %v", pair, err)
+			}
+			cas := []struct {
+				name  string
+				pair  *api.KVPair
+				write func(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error)
+				ok    bool
+			}{
+				{"create existing", &api.KVPair{Key: "a/1", Value: []byte("x")}, kv.CAS, false},
+				{"create new", &api.KVPair{Key: "c/1", Value: []byte("x")}, kv.CAS, true},
+				{"update stale", &api.KVPair{Key: "a/1", Value: []byte("x"), ModifyIndex: pair.ModifyIndex + 100}, kv.CAS, false},
+				{"update current", &api.KVPair{Key: "a/1", Value: []byte("x"), ModifyIndex: pair.ModifyIndex}, kv.CAS, true},
+				{"delete stale", &api.KVPair{Key: "a/1", ModifyIndex: pair.ModifyIndex}, kv.DeleteCAS, false},
+				{"delete without index", &api.KVPair{Key: "b/1"}, kv.DeleteCAS, false},
+			}
+			for _, tt := range cas {
+				ok, _, err := tt.write(tt.pair, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok != tt.ok {
+					t.Errorf("%s: got %v, want %v", tt.name, ok, tt.ok)
+				}
+			}
+
+			updated, _, err := kv.Get("a/1", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated.Value) != "x" || updated.CreateIndex != pair.CreateIndex || updated.ModifyIndex <= pair.ModifyIndex {
+				t.Errorf("updated pair %+v, created as %+v", updated, pair)
+			}
+			if ok, _, err := kv.DeleteCAS(updated, nil); err != nil || !ok {
+				t.Errorf("delete current: %v, %v", ok, err)
+			}
+			if _, err := kv.DeleteTree("a/", nil); err != nil {
+				t.Fatal(err)
+			}
+			if pairs, _, err := kv.List("", nil); err != nil || len(pairs) != 2 {
+				t.Errorf("left %d pairs, want b/1 and c/1: %v", len(pairs), err)
+			}
+		})
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "config.db")
+	store, err := NewBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := seedRetention(t, store)
+	seedPages(t, store, 3)
+	config, err := store.GetConf(ctx, id, "1.0.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddConfigVersion(ctx, &Config{Id: id, Version: "1.0.4", Entries: map[string]string{}}); !errors.Is(err, ErrVersionExists) {
+		t.Errorf("add existing version: got %v, want %v", err, ErrVersionExists)
+	}
+	if _, err := store.Delete(ctx, id, "1.0.4", config.Index+100); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("delete at a stale index: got %v, want %v", err, ErrPreconditionFailed)
+	}
+	if _, err := store.Delete(ctx, id, "1.0.4", config.Index); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err := store.RestoreTrashedConfig(ctx, id, "1.0.4"); err != nil {
+		t.Errorf("restore after reopening: %v", err)
+	}
+	report, err := store.Prune(ctx, RetentionPolicy{KeepLast: 2}, time.Now().Add(48*time.Hour), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pruned := []*PrunedVersion{}
+	for _, v := range report.Configs {
+		if v.Id == id {
+			pruned = append(pruned, v)
+		}
+	}
+	if got, want := prunedVersions(pruned), []string{"1.0.2", "1.0.1", "1.0.0", "0.1.0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("prune got %v, want %v", got, want)
+	}
+	configs, next, err := store.GetAll(ctx, ListOptions{Limit: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 4 || next == "" {
+		t.Errorf("got %d configs and cursor %q, want a page of 4 and more", len(configs), next)
+	}
+	if applied, err := store.Migrate(ctx); err != nil || len(applied) != 0 {
+		t.Errorf("migrations applied again after reopening: %v, %v", applied, err)
+	}
+}
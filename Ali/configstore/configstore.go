@@ -3,17 +3,18 @@ package configstore
 import (
 	tracer "Ali/tracer"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"github.com/google/uuid"
 	"github.com/hashicorp/consul/api"
 	"golang.org/x/net/context"
+	"io"
+	"net/url"
 	"os"
 	"sort"
+	"time"
 )
 
 type ConfigStore struct {
-	cli *api.Client
+	kv kvBackend
 }
 
 func New() (*ConfigStore, error) {
@@ -28,64 +29,155 @@ func New() (*ConfigStore, error) {
 	}
 
 	return &ConfigStore{
-		cli: client,
+		kv: client.KV(),
 	}, nil
 }
 
+// Close releases the backend of the store. Only the bbolt backend holds a
+// resource, the Consul and in-memory ones have nothing to release.
+func (cs *ConfigStore) Close() error {
+	if closer, ok := cs.kv.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 func (cs *ConfigStore) Post(ctx context.Context, config *Config) (*Config, error) {
 	span := tracer.StartSpanFromContext(ctx, "CreateConfig")
 	defer span.Finish()
-	kv := cs.cli.KV()
+	if err := validVersion(config.Version); err != nil {
+		return nil, err
+	}
+	state, err := validState(config.State)
+	if err != nil {
+		return nil, err
+	}
+	config.State = state
 
 	sid, rid := generateKey(config.Version)
 	config.Id = rid
 
+	meta, err := cs.configMetadata(ctx, config, false)
+	if err != nil {
+		return nil, err
+	}
+	config.Meta = meta
 	data, err := json.Marshal(config)
 	if err != nil {
 		return nil, err
 	}
 
-	p := &api.KVPair{Key: sid, Value: data}
-	_, err = kv.Put(p, nil)
+	config.Index, err = cs.create(sid, data)
+	if err != nil {
+		return nil, err
+	}
+	err = cs.indexConfigEntries(ctx, config)
 	if err != nil {
 		return nil, err
 	}
 
 	return config, nil
 }
-func (cs *ConfigStore) CheckId(ctx context.Context, reqId string) bool {
-	span := tracer.StartSpanFromContext(ctx, "findId")
+
+// MaxIdempotencyKeyLength is the longest idempotency key accepted.
+const MaxIdempotencyKeyLength = 255
+
+// ReserveId claims an idempotency key by storing pending under it when the
+// key is free or its record expired. Otherwise the record already stored
+// is returned, it is still Pending while the first request is handled.
+func (cs *ConfigStore) ReserveId(ctx context.Context, reqId string, pending *IdempotentResponse) (*IdempotentResponse, error) {
+	span := tracer.StartSpanFromContext(ctx, "ReserveId")
 	defer span.Finish()
-	kv := cs.cli.KV()
-	k, _, err := kv.Get(reqId, nil)
-	if err != nil || k == nil {
-		return false
+	if len(reqId) > MaxIdempotencyKeyLength {
+		return nil, fmt.Errorf("%w, it is longer than %d bytes", ErrInvalidIdempotency, MaxIdempotencyKeyLength)
+	}
+	kv := cs.kv
+	key := idempotencyKey(ctx, reqId)
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return nil, err
 	}
+	for {
+		ok, _, err := kv.CAS(&api.KVPair{Key: key, Value: data}, nil)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return nil, nil
+		}
+		k, _, err := kv.Get(key, nil)
+		if err != nil {
+			return nil, err
+		}
+		if k == nil {
+			continue
+		}
+		resp := &IdempotentResponse{}
+		err = json.Unmarshal(k.Value, resp)
+		if err == nil && (resp.ExpiresAt.IsZero() || resp.ExpiresAt.After(time.Now())) {
+			return resp, nil
+		}
+		// expired or unreadable, take it over
+		if _, _, err := kv.DeleteCAS(k, nil); err != nil {
+			return nil, err
+		}
+	}
+}
 
-	return true
+func (cs *ConfigStore) SaveId(ctx context.Context, reqId string, resp *IdempotentResponse) error {
+	span := tracer.StartSpanFromContext(ctx, "SaveRequestId")
+	defer span.Finish()
+	kv := cs.kv
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	p := &api.KVPair{Key: idempotencyKey(ctx, reqId), Value: data}
+	_, err = kv.Put(p, nil)
+	return err
+}
 
+// ReleaseId frees a reserved idempotency key so the request can be retried.
+func (cs *ConfigStore) ReleaseId(ctx context.Context, reqId string) error {
+	span := tracer.StartSpanFromContext(ctx, "ReleaseId")
+	defer span.Finish()
+	_, err := cs.kv.Delete(idempotencyKey(ctx, reqId), nil)
+	return err
 }
 
-func (cs *ConfigStore) SaveId(ctx context.Context) string {
-	span := tracer.StartSpanFromContext(ctx, "SaveRequestId")
+// SweepIds deletes idempotency keys that expired before now and
+// returns how many were removed.
+func (cs *ConfigStore) SweepIds(ctx context.Context, now time.Time) (int, error) {
+	span := tracer.StartSpanFromContext(ctx, "SweepIds")
 	defer span.Finish()
-	kv := cs.cli.KV()
-	idempotencyId := uuid.New().String()
-	p := &api.KVPair{Key: idempotencyId, Value: nil}
-	_, err := kv.Put(p, nil)
+	kv := cs.kv
+	data, _, err := kv.List(allIdempotency, nil)
 	if err != nil {
-		return "error"
+		return 0, err
+	}
+	removed := 0
+	for _, pair := range data {
+		resp := &IdempotentResponse{}
+		if err := json.Unmarshal(pair.Value, resp); err == nil && resp.ExpiresAt.After(now) {
+			continue
+		}
+		ok, _, err := kv.DeleteCAS(pair, nil)
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed++
+		}
 	}
-	return idempotencyId
+	return removed, nil
 }
 
-func (cs *ConfigStore) GetAll(ctx context.Context) ([]*Config, error) {
+func (cs *ConfigStore) GetAll(ctx context.Context, opts ListOptions) ([]*Config, string, error) {
 	span := tracer.StartSpanFromContext(ctx, "Get all")
 	defer span.Finish()
-	kv := cs.cli.KV()
-	data, _, err := kv.List(all, nil)
+	data, next, err := cs.page(tracer.ContextWithSpan(ctx, span), all, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	posts := []*Config{}
@@ -93,20 +185,19 @@ func (cs *ConfigStore) GetAll(ctx context.Context) ([]*Config, error) {
 		post := &Config{}
 		err = json.Unmarshal(pair.Value, post)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		posts = append(posts, post)
 	}
 
-	return posts, nil
+	return posts, next, nil
 }
-func (cs *ConfigStore) GetAllGroups(ctx context.Context) ([]*Group, error) {
+func (cs *ConfigStore) GetAllGroups(ctx context.Context, opts ListOptions) ([]*Group, string, error) {
 	span := tracer.StartSpanFromContext(ctx, "Get groups")
 	defer span.Finish()
-	kv := cs.cli.KV()
-	data, _, err := kv.List(allG, nil)
+	data, next, err := cs.page(tracer.ContextWithSpan(ctx, span), allG, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	posts := []*Group{}
@@ -114,72 +205,100 @@ func (cs *ConfigStore) GetAllGroups(ctx context.Context) ([]*Group, error) {
 		group := &Group{}
 		err = json.Unmarshal(pair.Value, group)
 		if err != nil {
-			return nil, err
+			return nil, "", err
+		}
+		err = cs.resolveRefs(ctx, group)
+		if err != nil {
+			return nil, "", err
 		}
 		posts = append(posts, group)
 	}
 
-	return posts, nil
+	return posts, next, nil
 }
 func (cs *ConfigStore) AddConfigVersion(ctx context.Context, config *Config) (*Config, error) {
 	span := tracer.StartSpanFromContext(ctx, "AddConfigVersion")
 	defer span.Finish()
-	kv := cs.cli.KV()
+	if err := validVersion(config.Version); err != nil {
+		return nil, err
+	}
+	state, err := validState(config.State)
+	if err != nil {
+		return nil, err
+	}
+	config.State = state
 	ctxKey := tracer.ContextWithSpan(ctx, span)
+	meta, err := cs.configMetadata(ctxKey, config, true)
+	if err != nil {
+		return nil, err
+	}
+	config.Meta = meta
 	data, err := json.Marshal(config)
 	if err != nil {
 		return nil, err
 	}
 
 	sid := configKeyVersion(ctxKey, config.Id, config.Version)
-	_, err = cs.GetConf(ctx, config.Id, config.Version)
 
-	if err == nil {
-		return nil, errors.New("version already exists! ")
-	}
-
-	p := &api.KVPair{Key: sid, Value: data}
-
-	putKey := tracer.StartSpanFromContext(ctxKey, "kv.put")
-	_, err = kv.Put(p, nil)
+	putKey := tracer.StartSpanFromContext(ctxKey, "kv.cas")
+	config.Index, err = cs.create(sid, data)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 	putKey.Finish()
+	err = cs.indexConfigEntries(ctxKey, config)
+	if err != nil {
+		return nil, err
+	}
 	return config, nil
 }
 func (cs *ConfigStore) GetConf(ctx context.Context, id string, version string) (*Config, error) {
 	span := tracer.StartSpanFromContext(ctx, "GetConfig")
 	defer span.Finish()
-	kv := cs.cli.KV()
+	kv := cs.kv
 
-	sid := configKeyVersion(ctx, id, version)
-	pair, _, err := kv.Get(sid, nil)
+	version, err := cs.resolveConfigVersion(ctx, id, version)
 	if err != nil {
 		return nil, err
 	}
+	sid := configKeyVersion(ctx, id, version)
+	pair, _, err := kv.Get(sid, nil)
+	if err != nil || pair == nil {
+		return nil, ErrNotFound
+	}
 	config := &Config{}
 	err = json.Unmarshal(pair.Value, config)
 	if err != nil {
 		return nil, err
 	}
+	config.Index = pair.ModifyIndex
 	return config, nil
 }
-func (cs *ConfigStore) Delete(ctx context.Context, id string, version string) (map[string]string, error) {
+func (cs *ConfigStore) Delete(ctx context.Context, id string, version string, index uint64) (map[string]string, error) {
 	span := tracer.StartSpanFromContext(ctx, "DeleteConfig")
 	defer span.Finish()
-	kv := cs.cli.KV()
-	_, err := kv.Delete(configKeyVersion(ctx, id, version), nil)
+	if err := cs.checkUnreferenced(ctx, id, version); err != nil {
+		return nil, err
+	}
+	err := cs.moveToTrash(ctx, ConfigKind, id, version, configKeyVersion(ctx, id, version), index)
 	if err != nil {
 		return nil, err
 	}
 	return map[string]string{"deleted": id}, nil
 }
+
+// DeleteConfigTree moves every version of a config to the trash and returns
+// how many were moved.
+func (cs *ConfigStore) DeleteConfigTree(ctx context.Context, id string) (int, error) {
+	span := tracer.StartSpanFromContext(ctx, "DeleteConfigTree")
+	defer span.Finish()
+	return cs.deleteTree(ctx, ConfigKind, id, configKey(ctx, id), nil)
+}
 func (cs *ConfigStore) GetConfVersions(ctx context.Context, id string) ([]*Config, error) {
 	span := tracer.StartSpanFromContext(ctx, "GetConfigVersion")
 	defer span.Finish()
-	kv := cs.cli.KV()
+	kv := cs.kv
 	sid := configKey(ctx, id)
 	data, _, err := kv.List(sid, nil)
 	if err != nil {
@@ -197,38 +316,53 @@ func (cs *ConfigStore) GetConfVersions(ctx context.Context, id string) ([]*Confi
 		configList = append(configList, config)
 
 	}
+	sort.SliceStable(configList, func(i, j int) bool {
+		return compareVersions(configList[i].Version, configList[j].Version) < 0
+	})
 	return configList, nil
 
 }
 func (cs *ConfigStore) Group(ctx context.Context, group *Group) (*Group, error) {
 	span := tracer.StartSpanFromContext(ctx, "CreateGroup")
 	defer span.Finish()
-	kv := cs.cli.KV()
+	if err := validVersion(group.Version); err != nil {
+		return nil, err
+	}
+	state, err := validState(group.State)
+	if err != nil {
+		return nil, err
+	}
+	group.State = state
+	err = cs.checkRefs(ctx, group)
+	if err != nil {
+		return nil, err
+	}
 	sid, rid := generateGroupKey(group.Version)
 	group.Id = rid
 
-	for _, v := range group.Config {
-		labels := ""
-		keys := make([]string, 0, len(v.Entries))
-		for k, _ := range v.Entries {
-			keys = append(keys, k)
-		}
-		sort.Strings(keys)
-		for _, k := range keys {
-			labels += k + ":" + v.Entries[k] + ","
-		}
-		labels = labels[:len(labels)-1]
-		configKeyGroupVersionlabel(ctx, group.Id, group.Version, labels)
-
+	meta, err := cs.groupMetadata(ctx, group, false)
+	if err != nil {
+		return nil, err
 	}
-
+	group.Meta = meta
 	data, err := json.Marshal(group)
 	if err != nil {
 		return nil, err
 	}
 
-	p := &api.KVPair{Key: sid, Value: data}
-	_, err = kv.Put(p, nil)
+	group.Index, err = cs.create(sid, data)
+	if err != nil {
+		return nil, err
+	}
+	err = cs.indexGroupLabels(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	err = cs.indexGroupEntries(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	err = cs.resolveRefs(ctx, group)
 	if err != nil {
 		return nil, err
 	}
@@ -239,45 +373,84 @@ func (cs *ConfigStore) Group(ctx context.Context, group *Group) (*Group, error)
 func (cs *ConfigStore) AddConfigGroupVersion(ctx context.Context, group *Group) (*Group, error) {
 	span := tracer.StartSpanFromContext(ctx, "AddVersionGroup")
 	defer span.Finish()
-	kv := cs.cli.KV()
+	if err := validVersion(group.Version); err != nil {
+		return nil, err
+	}
+	state, err := validState(group.State)
+	if err != nil {
+		return nil, err
+	}
+	group.State = state
+	err = cs.checkRefs(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := cs.groupMetadata(ctx, group, true)
+	if err != nil {
+		return nil, err
+	}
+	group.Meta = meta
 	data, err := json.Marshal(group)
+	if err != nil {
+		return nil, err
+	}
 
 	sid := configKeyGroupVersion(ctx, group.Id, group.Version)
-	_, err = cs.GetGroup(ctx, group.Id, group.Version)
-
-	if err == nil {
-		return nil, errors.New("version already exists! ")
+	group.Index, err = cs.create(sid, data)
+	if err != nil {
+		return nil, err
 	}
-
-	p := &api.KVPair{Key: sid, Value: data}
-	_, err = kv.Put(p, nil)
+	err = cs.indexGroupLabels(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	err = cs.indexGroupEntries(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	err = cs.resolveRefs(ctx, group)
 	if err != nil {
 		return nil, err
 	}
 	return group, nil
 }
-func (cs *ConfigStore) DeleteGroup(ctx context.Context, id string, version string) (map[string]string, error) {
+func (cs *ConfigStore) DeleteGroup(ctx context.Context, id string, version string, index uint64) (map[string]string, error) {
 	span := tracer.StartSpanFromContext(ctx, "DeleteGroup")
 	defer span.Finish()
-	kv := cs.cli.KV()
-	_, err := kv.Delete(configKeyGroupVersion(ctx, id, version), nil)
+	err := cs.moveToTrash(ctx, GroupKind, id, version, configKeyGroupVersion(ctx, id, version), index)
+	if err != nil {
+		return nil, err
+	}
+	err = cs.unindexGroupLabels(ctx, id, version)
 	if err != nil {
 		return nil, err
 	}
 	return map[string]string{"deleted": id}, nil
 }
+
+// DeleteGroupTree moves every version of a group to the trash, drops its
+// label index and returns how many versions were moved.
+func (cs *ConfigStore) DeleteGroupTree(ctx context.Context, id string) (int, error) {
+	span := tracer.StartSpanFromContext(ctx, "DeleteGroupTree")
+	defer span.Finish()
+	return cs.deleteTree(ctx, GroupKind, id, configKeyGroup(ctx, id), cs.unindexGroupLabels)
+}
 func (cs *ConfigStore) GetGroup(ctx context.Context, id string, version string) (*Group, error) {
 	span := tracer.StartSpanFromContext(ctx, "GetGroup")
 	defer span.Finish()
-	kv := cs.cli.KV()
+	kv := cs.kv
 	ctxKey := tracer.ContextWithSpan(ctx, span)
 
+	version, err := cs.resolveGroupVersion(ctxKey, id, version)
+	if err != nil {
+		return nil, err
+	}
 	sid := configKeyGroupVersion(ctxKey, id, version)
 	getKey := tracer.StartSpanFromContext(ctxKey, "kv.get")
 
 	pair, _, err := kv.Get(sid, nil)
 	if err != nil || pair == nil {
-		return nil, errors.New("not existing")
+		return nil, ErrNotFound
 	}
 	getKey.Finish()
 	group := &Group{}
@@ -285,12 +458,17 @@ func (cs *ConfigStore) GetGroup(ctx context.Context, id string, version string)
 	if err != nil {
 		return nil, err
 	}
+	group.Index = pair.ModifyIndex
+	err = cs.resolveRefs(ctxKey, group)
+	if err != nil {
+		return nil, err
+	}
 	return group, nil
 }
 func (cs *ConfigStore) GetConfGroupVersions(ctx context.Context, id string) ([]*Group, error) {
 	span := tracer.StartSpanFromContext(ctx, "FindConfVersions")
 	defer span.Finish()
-	kv := cs.cli.KV()
+	kv := cs.kv
 	sid := configKeyGroup(ctx, id)
 	data, _, err := kv.List(sid, nil)
 	if err != nil {
@@ -305,25 +483,140 @@ func (cs *ConfigStore) GetConfGroupVersions(ctx context.Context, id string) ([]*
 		if err != nil {
 			return nil, err
 		}
+		err = cs.resolveRefs(ctx, group)
+		if err != nil {
+			return nil, err
+		}
 		groupList = append(groupList, group)
 
 	}
+	sort.SliceStable(groupList, func(i, j int) bool {
+		return compareVersions(groupList[i].Version, groupList[j].Version) < 0
+	})
 	return groupList, nil
 
 }
 
 func (cs *ConfigStore) Put(ctx context.Context, group *Group) (*Group, error) {
-	span := tracer.StartSpanFromContext(ctx, "FindConfVersions")
+	span := tracer.StartSpanFromContext(ctx, "PutGroup")
 	defer span.Finish()
-	kv := cs.cli.KV()
+	kv := cs.kv
+	if group.Index == 0 {
+		return nil, ErrPreconditionFailed
+	}
+	current, err := cs.GetGroup(ctx, group.Id, group.Version)
+	if err != nil {
+		return nil, err
+	}
+	if current.State != DraftState {
+		return nil, ErrImmutable
+	}
+	group.State = DraftState
+	err = cs.checkRefs(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	group.Meta = nil
+	if current.Meta != nil {
+		group.Meta = &Metadata{Parent: current.Meta.Parent, Tags: current.Meta.Tags}
+	}
+	group.Meta, err = cs.groupMetadata(ctx, group, false)
+	if err != nil {
+		return nil, err
+	}
 	data, err := json.Marshal(group)
+	if err != nil {
+		return nil, err
+	}
 
 	sid := configKeyGroupVersion(ctx, group.Id, group.Version)
 
-	p := &api.KVPair{Key: sid, Value: data}
-	_, err = kv.Put(p, nil)
+	p := &api.KVPair{Key: sid, Value: data, ModifyIndex: group.Index}
+	ok, _, err := kv.CAS(p, nil)
 	if err != nil {
 		return nil, err
 	}
-	return group, nil
+	if !ok {
+		return nil, ErrPreconditionFailed
+	}
+	err = cs.unindexGroupLabels(ctx, group.Id, group.Version)
+	if err != nil {
+		return nil, err
+	}
+	err = cs.unindexGroupEntries(ctx, current)
+	if err != nil {
+		return nil, err
+	}
+	err = cs.indexGroupLabels(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	err = cs.indexGroupEntries(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	return cs.GetGroup(ctx, group.Id, group.Version)
+}
+
+// deleteTree moves every version of id stored under prefix to the trash,
+// each at the modify index it was listed with, and lets cleanup drop what
+// else was stored for it. It stops with ErrPreconditionFailed when a
+// version changes meanwhile; the versions trashed until then stay
+// restorable. Nothing is removed while a group references a config version.
+func (cs *ConfigStore) deleteTree(ctx context.Context, kind string, id string, prefix string, cleanup func(ctx context.Context, id string, version string) error) (int, error) {
+	span := tracer.StartSpanFromContext(ctx, "deleteTree")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+	data, _, err := cs.kv.List(prefix, nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) == 0 {
+		return 0, ErrNotFound
+	}
+	if kind == ConfigKind {
+		for _, pair := range data {
+			version, err := url.PathUnescape(pair.Key[len(prefix):])
+			if err != nil {
+				continue
+			}
+			if err := cs.checkUnreferenced(ctx, id, version); err != nil {
+				return 0, err
+			}
+		}
+	}
+	removed := 0
+	for _, pair := range data {
+		version, err := url.PathUnescape(pair.Key[len(prefix):])
+		if err != nil {
+			continue
+		}
+		if err := cs.moveToTrash(ctx, kind, id, version, pair.Key, pair.ModifyIndex); err != nil {
+			return removed, err
+		}
+		if cleanup != nil {
+			if err := cleanup(ctx, id, version); err != nil {
+				return removed, err
+			}
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// create writes a new key with check-and-set index 0, so it fails with
+// ErrVersionExists instead of overwriting a concurrently created version.
+func (cs *ConfigStore) create(key string, data []byte) (uint64, error) {
+	ok, _, err := cs.kv.CAS(&api.KVPair{Key: key, Value: data}, nil)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrVersionExists
+	}
+	pair, _, err := cs.kv.Get(key, nil)
+	if err != nil || pair == nil {
+		return 0, err
+	}
+	return pair.ModifyIndex, nil
 }
@@ -0,0 +1,300 @@
+package configstore
+
+import (
+	tracer "Ali/tracer"
+	"encoding/json"
+	"golang.org/x/net/context"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type ValueChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+type EntriesDiff struct {
+	Added   map[string]string      `json:"added"`
+	Removed map[string]string      `json:"removed"`
+	Changed map[string]ValueChange `json:"changed"`
+}
+
+type ConfigDiff struct {
+	Id   string `json:"id"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	EntriesDiff
+}
+
+// ConfigGDiff is a config of both group versions whose entries or
+// reference changed. From and Index are its positions in the from and to
+// versions.
+type ConfigGDiff struct {
+	From  int          `json:"from"`
+	Index int          `json:"index"`
+	Ref   *ValueChange `json:"ref,omitempty"`
+	EntriesDiff
+
+	// ref tells the entries are resolved from a reference and not stored.
+	ref bool
+}
+
+// GroupDiff pairs the configs of two group versions by the config they
+// reference, then by equal entries, and the rest by their order.
+type GroupDiff struct {
+	Id      string         `json:"id"`
+	From    string         `json:"from"`
+	To      string         `json:"to"`
+	Added   []*ConfigG     `json:"added"`
+	Removed []*ConfigG     `json:"removed"`
+	Changed []*ConfigGDiff `json:"changed"`
+
+	// matches holds for every config of the to version the position of
+	// its pair in the from version, -1 for added ones.
+	matches   []int
+	removedAt []int
+	fromLen   int
+}
+
+// PatchOperation is a single RFC 6902 JSON Patch operation.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON leaves value out only for remove, every other operation
+// carries it even when it is null or an empty string.
+func (op PatchOperation) MarshalJSON() ([]byte, error) {
+	type operation PatchOperation
+	if op.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{op.Op, op.Path})
+	}
+	return json.Marshal(operation(op))
+}
+
+func diffEntries(from map[string]string, to map[string]string) EntriesDiff {
+	d := EntriesDiff{
+		Added:   map[string]string{},
+		Removed: map[string]string{},
+		Changed: map[string]ValueChange{},
+	}
+	for k, v := range from {
+		nv, ok := to[k]
+		switch {
+		case !ok:
+			d.Removed[k] = v
+		case nv != v:
+			d.Changed[k] = ValueChange{Old: v, New: nv}
+		}
+	}
+	for k, v := range to {
+		if _, ok := from[k]; !ok {
+			d.Added[k] = v
+		}
+	}
+	return d
+}
+
+func (d EntriesDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func diffConfigs(from *Config, to *Config) *ConfigDiff {
+	return &ConfigDiff{
+		Id:          to.Id,
+		From:        from.Version,
+		To:          to.Version,
+		EntriesDiff: diffEntries(from.Entries, to.Entries),
+	}
+}
+
+func diffGroups(from *Group, to *Group) *GroupDiff {
+	d := &GroupDiff{
+		Id:        to.Id,
+		From:      from.Version,
+		To:        to.Version,
+		Added:     []*ConfigG{},
+		Removed:   []*ConfigG{},
+		Changed:   []*ConfigGDiff{},
+		matches:   make([]int, len(to.Config)),
+		removedAt: []int{},
+		fromLen:   len(from.Config),
+	}
+	for j := range d.matches {
+		d.matches[j] = -1
+	}
+	paired := make([]bool, len(from.Config))
+	pair := func(same func(f *ConfigG, t *ConfigG) bool) {
+		for j, t := range to.Config {
+			if d.matches[j] >= 0 {
+				continue
+			}
+			for i, f := range from.Config {
+				if !paired[i] && same(f, t) {
+					paired[i] = true
+					d.matches[j] = i
+					break
+				}
+			}
+		}
+	}
+	pair(func(f *ConfigG, t *ConfigG) bool {
+		return f.Ref != nil && t.Ref != nil && f.Ref.Id == t.Ref.Id
+	})
+	pair(func(f *ConfigG, t *ConfigG) bool {
+		return f.Ref == nil && t.Ref == nil && Labels(f.Entries) == Labels(t.Entries)
+	})
+	pair(func(f *ConfigG, t *ConfigG) bool {
+		return f.Ref == nil && t.Ref == nil
+	})
+
+	for j, i := range d.matches {
+		t := to.Config[j]
+		if i < 0 {
+			d.Added = append(d.Added, t)
+			continue
+		}
+		f := from.Config[i]
+		c := &ConfigGDiff{From: i, Index: j, EntriesDiff: diffEntries(f.Entries, t.Entries), ref: t.Ref != nil}
+		if t.Ref != nil && f.Ref.Version != t.Ref.Version {
+			c.Ref = &ValueChange{Old: f.Ref.Version, New: t.Ref.Version}
+		}
+		if c.Ref != nil || !c.EntriesDiff.empty() {
+			d.Changed = append(d.Changed, c)
+		}
+	}
+	for i, f := range from.Config {
+		if !paired[i] {
+			d.Removed = append(d.Removed, f)
+			d.removedAt = append(d.removedAt, i)
+		}
+	}
+	return d
+}
+
+func (cs *ConfigStore) DiffConfig(ctx context.Context, id string, from string, to string) (*ConfigDiff, error) {
+	span := tracer.StartSpanFromContext(ctx, "DiffConfig")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+	fromConfig, err := cs.GetConf(ctx, id, from)
+	if err != nil {
+		return nil, err
+	}
+	toConfig, err := cs.GetConf(ctx, id, to)
+	if err != nil {
+		return nil, err
+	}
+	return diffConfigs(fromConfig, toConfig), nil
+}
+
+func (cs *ConfigStore) DiffGroup(ctx context.Context, id string, from string, to string) (*GroupDiff, error) {
+	span := tracer.StartSpanFromContext(ctx, "DiffGroup")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+	fromGroup, err := cs.GetGroup(ctx, id, from)
+	if err != nil {
+		return nil, err
+	}
+	toGroup, err := cs.GetGroup(ctx, id, to)
+	if err != nil {
+		return nil, err
+	}
+	return diffGroups(fromGroup, toGroup), nil
+}
+
+// escapePointer escapes a key for use as a JSON Pointer token.
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (d EntriesDiff) patch(base string) []PatchOperation {
+	ops := []PatchOperation{}
+	for _, k := range sortedKeys(d.Removed) {
+		ops = append(ops, PatchOperation{Op: "remove", Path: base + escapePointer(k)})
+	}
+	changed := make(map[string]string, len(d.Changed))
+	for k, c := range d.Changed {
+		changed[k] = c.New
+	}
+	for _, k := range sortedKeys(changed) {
+		ops = append(ops, PatchOperation{Op: "replace", Path: base + escapePointer(k), Value: changed[k]})
+	}
+	for _, k := range sortedKeys(d.Added) {
+		ops = append(ops, PatchOperation{Op: "add", Path: base + escapePointer(k), Value: d.Added[k]})
+	}
+	return ops
+}
+
+// Patch returns the diff as a JSON Patch that turns the from version into the to version.
+func (d *ConfigDiff) Patch() []PatchOperation {
+	ops := []PatchOperation{{Op: "replace", Path: "/version", Value: d.To}}
+	return append(ops, d.EntriesDiff.patch("/entries/")...)
+}
+
+// Patch removes the configs missing from the to version, then walks the to
+// version moving every kept config into place, patching what changed and
+// adding new ones. Entries resolved from a reference are not stored, so
+// only a changed reference version is patched for them.
+func (d *GroupDiff) Patch() []PatchOperation {
+	ops := []PatchOperation{{Op: "replace", Path: "/version", Value: d.To}}
+	removed := make(map[int]bool, len(d.removedAt))
+	for k := len(d.removedAt) - 1; k >= 0; k-- {
+		removed[d.removedAt[k]] = true
+		ops = append(ops, PatchOperation{Op: "remove", Path: "/config/" + strconv.Itoa(d.removedAt[k])})
+	}
+	// order holds the from position of every config at its current place
+	order := []int{}
+	for i := 0; i < d.fromLen; i++ {
+		if !removed[i] {
+			order = append(order, i)
+		}
+	}
+	changed := make(map[int]*ConfigGDiff, len(d.Changed))
+	for _, c := range d.Changed {
+		changed[c.Index] = c
+	}
+
+	added := 0
+	for j, i := range d.matches {
+		path := "/config/" + strconv.Itoa(j)
+		if i < 0 {
+			ops = append(ops, PatchOperation{Op: "add", Path: path, Value: d.Added[added]})
+			added++
+			order = append(order[:j], append([]int{-1}, order[j:]...)...)
+			continue
+		}
+		at := j
+		for order[at] != i {
+			at++
+		}
+		if at != j {
+			ops = append(ops, PatchOperation{Op: "move", From: "/config/" + strconv.Itoa(at), Path: path})
+			order = append(order[:at], order[at+1:]...)
+			order = append(order[:j], append([]int{i}, order[j:]...)...)
+		}
+		c, ok := changed[j]
+		switch {
+		case !ok:
+		case c.Ref != nil:
+			ops = append(ops, PatchOperation{Op: "replace", Path: path + "/ref/version", Value: c.Ref.New})
+		case !c.ref:
+			ops = append(ops, c.EntriesDiff.patch(path+"/entries/")...)
+		}
+	}
+	return ops
+}
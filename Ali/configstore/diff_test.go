@@ -0,0 +1,150 @@
+package configstore
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestConfigDiffPatchRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to map[string]string
+	}{
+		{"unchanged", map[string]string{"a": "1"}, map[string]string{"a": "1"}},
+		{"added", map[string]string{}, map[string]string{"a": "1", "b": ""}},
+		{"removed", map[string]string{"a": "1", "b": "2"}, map[string]string{"b": "2"}},
+		{"changed to empty", map[string]string{"a": "1"}, map[string]string{"a": ""}},
+		{"escaped keys", map[string]string{"a/b": "1", "c~d": "2"}, map[string]string{"a/b": "3", "e~1f": "4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from := &Config{Id: "c", Version: "1.0.0", Entries: tt.from}
+			to := &Config{Id: "c", Version: "1.0.1", Entries: tt.to}
+			patch, err := json.Marshal(diffConfigs(from, to).Patch())
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, err := json.Marshal(from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			patched, err := applyJSONPatch(doc, patch)
+			if err != nil {
+				t.Fatalf("%s: %v", patch, err)
+			}
+			got := &Config{}
+			if err := json.Unmarshal(patched, got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, to) {
+				t.Errorf("%s applied gives %+v, want %+v", patch, got, to)
+			}
+		})
+	}
+}
+
+func TestGroupDiffPatchRoundTrip(t *testing.T) {
+	member := func(entries map[string]string) *ConfigG { return &ConfigG{Entries: entries} }
+	a, b, c := member(map[string]string{"a": "1"}), member(map[string]string{"b": "2"}), member(map[string]string{"c": "3"})
+	tests := []struct {
+		name                    string
+		from, to                []*ConfigG
+		added, removed, changed int
+	}{
+		{"member added", []*ConfigG{a}, []*ConfigG{a, b}, 1, 0, 0},
+		{"member inserted at the front", []*ConfigG{a, b}, []*ConfigG{c, a, b}, 1, 0, 0},
+		{"members removed", []*ConfigG{a, b, c}, []*ConfigG{b}, 0, 2, 0},
+		{"members reordered", []*ConfigG{a, b, c}, []*ConfigG{c, a, b}, 0, 0, 0},
+		{"member changed", []*ConfigG{member(map[string]string{"a": "1", "b": "2"})},
+			[]*ConfigG{member(map[string]string{"a": "9", "c": "3"})}, 0, 0, 1},
+		{"unpaired members changed by order", []*ConfigG{a, member(map[string]string{"x": "1"}), b},
+			[]*ConfigG{b, c, member(map[string]string{"x": "2"})}, 0, 0, 2},
+		{"moved, changed and added", []*ConfigG{a, b},
+			[]*ConfigG{member(map[string]string{"x": "1"}), b, c, member(map[string]string{"a": "2"})}, 2, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from := &Group{Id: "g", Version: "1.0.0", Config: tt.from}
+			to := &Group{Id: "g", Version: "2.0.0", Config: tt.to}
+			diff := diffGroups(from, to)
+			if len(diff.Added) != tt.added || len(diff.Removed) != tt.removed || len(diff.Changed) != tt.changed {
+				t.Errorf("%d added, %d removed, %d changed, want %d, %d, %d", len(diff.Added), len(diff.Removed), len(diff.Changed), tt.added, tt.removed, tt.changed)
+			}
+			patch, err := json.Marshal(diff.Patch())
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, err := json.Marshal(from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			patched, err := applyJSONPatch(doc, patch)
+			if err != nil {
+				t.Fatalf("%s: %v", patch, err)
+			}
+			got := &Group{}
+			if err := json.Unmarshal(patched, got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, to) {
+				t.Errorf("%s applied gives %+v, want %+v", patch, got, to)
+			}
+		})
+	}
+}
+
+func TestGroupDiffRefs(t *testing.T) {
+	ref := func(id string, version string) *ConfigG {
+		return &ConfigG{Entries: map[string]string{"a": "1"}, Ref: &ConfigRef{Id: id, Version: version}}
+	}
+	tests := []struct {
+		name           string
+		from, to       *ConfigG
+		added, removed int
+		ref            *ValueChange
+	}{
+		{"unchanged", ref("c1", "1.0.0"), ref("c1", "1.0.0"), 0, 0, nil},
+		{"other config with the same entries", ref("c1", "1.0.0"), ref("c2", "1.0.0"), 1, 1, nil},
+		{"other version", ref("c1", "1.0.0"), ref("c1", "latest"), 0, 0, &ValueChange{Old: "1.0.0", New: "latest"}},
+		{"inlined", ref("c1", "1.0.0"), &ConfigG{Entries: map[string]string{"a": "1"}}, 1, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := diffGroups(&Group{Config: []*ConfigG{tt.from}}, &Group{Config: []*ConfigG{tt.to}})
+			if len(diff.Added) != tt.added || len(diff.Removed) != tt.removed {
+				t.Errorf("%d added, %d removed, want %d and %d", len(diff.Added), len(diff.Removed), tt.added, tt.removed)
+			}
+			var got *ValueChange
+			if len(diff.Changed) > 0 {
+				got = diff.Changed[0].Ref
+			}
+			if !reflect.DeepEqual(got, tt.ref) {
+				t.Errorf("ref change %+v, want %+v", got, tt.ref)
+			}
+		})
+	}
+}
+
+func TestPatchOperationMarshal(t *testing.T) {
+	tests := []struct {
+		op   PatchOperation
+		want string
+	}{
+		{PatchOperation{Op: "remove", Path: "/a", Value: "x"}, `{"op":"remove","path":"/a"}`},
+		{PatchOperation{Op: "replace", Path: "/a", Value: ""}, `{"op":"replace","path":"/a","value":""}`},
+		{PatchOperation{Op: "add", Path: "/a", Value: nil}, `{"op":"add","path":"/a","value":null}`},
+		{PatchOperation{Op: "move", Path: "/b", From: "/a"}, `{"op":"move","path":"/b","from":"/a","value":null}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.op.Op, func(t *testing.T) {
+			got, err := json.Marshal(tt.op)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package configstore
+
+import "errors"
+
+var (
+	ErrNotFound           = errors.New("not existing")
+	ErrPreconditionFailed = errors.New("resource was modified, reload and try again")
+	ErrVersionExists      = errors.New("version already exists")
+	ErrInvalidVersion     = errors.New("invalid version")
+	ErrInvalidPatch       = errors.New("patch cannot be applied")
+	ErrInvalidState       = errors.New("invalid state")
+	ErrInvalidCursor      = errors.New("invalid cursor")
+	ErrInvalidLimit       = errors.New("invalid limit")
+	ErrInvalidQuery       = errors.New("invalid query")
+	ErrInvalidReference   = errors.New("invalid config reference")
+	ErrReferenced         = errors.New("config version is referenced by a group")
+	ErrInvalidIdempotency = errors.New("invalid idempotency-key")
+	ErrImmutable          = errors.New("published versions are read-only, create a new version instead")
+)
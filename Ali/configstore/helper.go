@@ -5,55 +5,143 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"golang.org/x/net/context"
+	"net/url"
+	"strings"
+	"time"
 )
 
+// Every id and version is a single path escaped segment and every prefix
+// used for listing ends with a separator, so one id never matches the keys
+// of another id that starts with it.
 const (
-	configId = "config/%s"
+	configId = "config/%s/"
 	config   = "config/%s/%s"
 	configV  = "config/%s/%s"
-	all      = "config"
+	all      = "config/"
 
-	grouplabel    = "group/%s/%s/%s"
+	grouplabel    = "label/%s/%s/%s/"
+	groupLabels   = "label/%s/%s/"
 	group         = "group/%s/%s"
-	configGroupId = "group/%s"
-	allG          = "group"
+	configGroupId = "group/%s/"
+	allG          = "group/"
+
+	idempotency    = "idempotency/%s"
+	allIdempotency = "idempotency/"
+
+	trash        = "trash/%s/%s/%s/%020d"
+	trashVersion = "trash/%s/%s/%s/"
+	allTrash     = "trash/"
+
+	schemaVersion = "schema/version"
+
+	entryIndex    = "index/entries/%s/%s/%s/%s/%s"
+	allEntryIndex = "index/entries/"
+	valueIndex    = "index/values/%s/%s/%s/%s/%s"
+	allValueIndex = "index/values/"
+	refIndex      = "index/refs/%s/%s/%s"
+	refIndexId    = "index/refs/%s/"
+	allRefIndex   = "index/refs/"
 )
 
+func segment(s string) string {
+	return url.PathEscape(s)
+}
+
+// splitKey splits the "{id}/{version}" rest of a config or group key.
+func splitKey(rest string) (string, string, bool) {
+	parts := strings.Split(rest, "/")
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	id, err := url.PathUnescape(parts[0])
+	if err != nil {
+		return "", "", false
+	}
+	version, err := url.PathUnescape(parts[1])
+	if err != nil {
+		return "", "", false
+	}
+	return id, version, true
+}
+
 func generateKey(version string) (string, string) {
 	id := uuid.New().String()
-	return fmt.Sprintf(config, id, version), id
+	return fmt.Sprintf(config, segment(id), segment(version)), id
 }
 func configKeyVersion(ctx context.Context, id string, version string) string {
 	span := tracer.StartSpanFromContext(ctx, "constructKeyVersion")
 	defer span.Finish()
-	return fmt.Sprintf(configV, id, version)
+	return fmt.Sprintf(configV, segment(id), segment(version))
 
 }
 func configKey(ctx context.Context, id string) string {
 	span := tracer.StartSpanFromContext(ctx, "ConstructConfigKey")
 	defer span.Finish()
-	return fmt.Sprintf(configId, id)
+	return fmt.Sprintf(configId, segment(id))
 }
 
 func generateGroupKey(version string) (string, string) {
 
 	id := uuid.New().String()
-	return fmt.Sprintf(group, id, version), id
+	return fmt.Sprintf(group, segment(id), segment(version)), id
 }
 func configKeyGroupVersion(ctx context.Context, id string, version string) string {
 	span := tracer.StartSpanFromContext(ctx, "ConstructKeyGroupVersion")
 	defer span.Finish()
-	return fmt.Sprintf(group, id, version)
+	return fmt.Sprintf(group, segment(id), segment(version))
 
 }
 func configKeyGroupVersionlabel(ctx context.Context, id string, version string, labels string) string {
 	span := tracer.StartSpanFromContext(ctx, "ConstructConfigKey")
 	defer span.Finish()
-	return fmt.Sprintf(grouplabel, id, version, labels)
+	return fmt.Sprintf(grouplabel, segment(id), segment(version), segment(labels))
 
 }
+func configKeyGroupLabels(ctx context.Context, id string, version string) string {
+	span := tracer.StartSpanFromContext(ctx, "configKeyGroupLabels")
+	defer span.Finish()
+	return fmt.Sprintf(groupLabels, segment(id), segment(version))
+}
 func configKeyGroup(ctx context.Context, id string) string {
 	span := tracer.StartSpanFromContext(ctx, "configKeyGroup")
 	defer span.Finish()
-	return fmt.Sprintf(configGroupId, id)
+	return fmt.Sprintf(configGroupId, segment(id))
+}
+func idempotencyKey(ctx context.Context, reqId string) string {
+	span := tracer.StartSpanFromContext(ctx, "idempotencyKey")
+	defer span.Finish()
+	return fmt.Sprintf(idempotency, segment(reqId))
+}
+
+// trashKey gives every deletion of a version its own key, ordered by the
+// time it was deleted.
+func trashKey(ctx context.Context, kind string, id string, version string, deletedAt time.Time) string {
+	span := tracer.StartSpanFromContext(ctx, "trashKey")
+	defer span.Finish()
+	return fmt.Sprintf(trash, kind, segment(id), segment(version), deletedAt.UnixNano())
+}
+func trashVersionKey(ctx context.Context, kind string, id string, version string) string {
+	span := tracer.StartSpanFromContext(ctx, "trashVersionKey")
+	defer span.Finish()
+	return fmt.Sprintf(trashVersion, kind, segment(id), segment(version))
+}
+func entryIndexKey(ctx context.Context, key string, value string, kind string, id string, version string) string {
+	span := tracer.StartSpanFromContext(ctx, "entryIndexKey")
+	defer span.Finish()
+	return fmt.Sprintf(entryIndex, segment(key), segment(value), kind, segment(id), segment(version))
+}
+func valueIndexKey(ctx context.Context, key string, value string, kind string, id string, version string) string {
+	span := tracer.StartSpanFromContext(ctx, "valueIndexKey")
+	defer span.Finish()
+	return fmt.Sprintf(valueIndex, segment(value), segment(key), kind, segment(id), segment(version))
+}
+func refIndexKey(ctx context.Context, configId string, groupId string, version string) string {
+	span := tracer.StartSpanFromContext(ctx, "refIndexKey")
+	defer span.Finish()
+	return fmt.Sprintf(refIndex, segment(configId), segment(groupId), segment(version))
+}
+func refIndexIdKey(ctx context.Context, configId string) string {
+	span := tracer.StartSpanFromContext(ctx, "refIndexIdKey")
+	defer span.Finish()
+	return fmt.Sprintf(refIndexId, segment(configId))
 }
@@ -0,0 +1,41 @@
+package configstore
+
+import (
+	"github.com/hashicorp/consul/api"
+	"strings"
+)
+
+// kvBackend is the subset of the Consul KV API used by ConfigStore.
+// *api.KV satisfies it directly, other backends emulate its semantics.
+type kvBackend interface {
+	Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+	Keys(prefix string, separator string, q *api.QueryOptions) ([]string, *api.QueryMeta, error)
+	Put(p *api.KVPair, q *api.WriteOptions) (*api.WriteMeta, error)
+	Delete(key string, w *api.WriteOptions) (*api.WriteMeta, error)
+	DeleteTree(prefix string, w *api.WriteOptions) (*api.WriteMeta, error)
+	CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error)
+	DeleteCAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error)
+}
+
+var _ kvBackend = (*api.KV)(nil)
+
+// keysUpTo trims keys after the first separator following the prefix and
+// drops duplicates, like Consul does for key listings with a separator.
+func keysUpTo(keys []string, prefix string, separator string) []string {
+	if separator == "" {
+		return keys
+	}
+	seen := map[string]bool{}
+	result := []string{}
+	for _, k := range keys {
+		if i := strings.Index(k[len(prefix):], separator); i >= 0 {
+			k = k[:len(prefix)+i+len(separator)]
+		}
+		if !seen[k] {
+			seen[k] = true
+			result = append(result, k)
+		}
+	}
+	return result
+}
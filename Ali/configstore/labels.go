@@ -0,0 +1,124 @@
+package configstore
+
+import (
+	tracer "Ali/tracer"
+	"encoding/json"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/net/context"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Labels builds the canonical "k:v,k:v" label string of a config,
+// with keys in sorted order. Keys and values are query escaped so a ':' or
+// ',' inside them cannot make two different configs look the same.
+func Labels(entries map[string]string) string {
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	labels := make([]string, 0, len(keys))
+	for _, k := range keys {
+		labels = append(labels, url.QueryEscape(k)+":"+url.QueryEscape(entries[k]))
+	}
+	return strings.Join(labels, ",")
+}
+
+// indexGroupLabels writes one label/{id}/{version}/{labels}/{n} key per
+// config of the group so filters can be answered with a prefix lookup.
+func (cs *ConfigStore) indexGroupLabels(ctx context.Context, group *Group) error {
+	span := tracer.StartSpanFromContext(ctx, "indexGroupLabels")
+	defer span.Finish()
+	ctxKey := tracer.ContextWithSpan(ctx, span)
+	kv := cs.kv
+	for i, v := range group.Config {
+		if v.Ref != nil {
+			// matched on read, its entries change with the config
+			continue
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		key := configKeyGroupVersionlabel(ctxKey, group.Id, group.Version, Labels(v.Entries)) + strconv.Itoa(i)
+		_, err = kv.Put(&api.KVPair{Key: key, Value: data}, nil)
+		if err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (cs *ConfigStore) unindexGroupLabels(ctx context.Context, id string, version string) error {
+	span := tracer.StartSpanFromContext(ctx, "unindexGroupLabels")
+	defer span.Finish()
+	_, err := cs.kv.DeleteTree(configKeyGroupLabels(ctx, id, version), nil)
+	return err
+}
+
+// FilterGroup returns the configs of a group version whose entries are
+// exactly the given labels.
+func (cs *ConfigStore) FilterGroup(ctx context.Context, id string, version string, labels map[string]string) ([]*ConfigG, error) {
+	span := tracer.StartSpanFromContext(ctx, "FilterGroup")
+	defer span.Finish()
+	kv := cs.kv
+	group, err := cs.GetGroup(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := kv.List(configKeyGroupVersionlabel(ctx, id, group.Version, Labels(labels)), nil)
+	if err != nil {
+		return nil, err
+	}
+	configs := []*ConfigG{}
+	for _, pair := range data {
+		config := &ConfigG{}
+		err = json.Unmarshal(pair.Value, config)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+	for _, c := range group.Config {
+		if c.Ref != nil && c.Entries != nil && Labels(c.Entries) == Labels(labels) {
+			configs = append(configs, c)
+		}
+	}
+	return configs, nil
+}
+
+// SelectGroup returns the configs of a group version matching the selector.
+func (cs *ConfigStore) SelectGroup(ctx context.Context, id string, version string, sel Selector) ([]*ConfigG, error) {
+	span := tracer.StartSpanFromContext(ctx, "SelectGroup")
+	defer span.Finish()
+	kv := cs.kv
+	group, err := cs.GetGroup(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := kv.List(configKeyGroupLabels(ctx, id, group.Version), nil)
+	if err != nil {
+		return nil, err
+	}
+	configs := []*ConfigG{}
+	for _, pair := range data {
+		config := &ConfigG{}
+		err = json.Unmarshal(pair.Value, config)
+		if err != nil {
+			return nil, err
+		}
+		if sel.Matches(config.Entries) {
+			configs = append(configs, config)
+		}
+	}
+	for _, c := range group.Config {
+		if c.Ref != nil && c.Entries != nil && sel.Matches(c.Entries) {
+			configs = append(configs, c)
+		}
+	}
+	return configs, nil
+}
@@ -0,0 +1,139 @@
+package configstore
+
+import (
+	"github.com/hashicorp/consul/api"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// memoryKV keeps all pairs in process memory. It mimics Consul: lists are
+// returned in lexical key order and every write bumps a global index.
+type memoryKV struct {
+	mu    sync.RWMutex
+	index uint64
+	data  map[string]*api.KVPair
+}
+
+func NewInMemory() *ConfigStore {
+	return &ConfigStore{
+		kv: &memoryKV{data: map[string]*api.KVPair{}},
+	}
+}
+
+func copyPair(p *api.KVPair) *api.KVPair {
+	c := *p
+	c.Value = append([]byte(nil), p.Value...)
+	return &c
+}
+
+func (m *memoryKV) Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.data[key]
+	if !ok {
+		return nil, &api.QueryMeta{LastIndex: m.index}, nil
+	}
+	return copyPair(p), &api.QueryMeta{LastIndex: m.index}, nil
+}
+
+func (m *memoryKV) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]string, 0)
+	for k := range m.data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	pairs := api.KVPairs{}
+	for _, k := range keys {
+		pairs = append(pairs, copyPair(m.data[k]))
+	}
+	return pairs, &api.QueryMeta{LastIndex: m.index}, nil
+}
+
+func (m *memoryKV) Keys(prefix string, separator string, q *api.QueryOptions) ([]string, *api.QueryMeta, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := []string{}
+	for k := range m.data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keysUpTo(keys, prefix, separator), &api.QueryMeta{LastIndex: m.index}, nil
+}
+
+func (m *memoryKV) Put(p *api.KVPair, q *api.WriteOptions) (*api.WriteMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(p)
+	return &api.WriteMeta{}, nil
+}
+
+func (m *memoryKV) put(p *api.KVPair) {
+	m.index++
+	stored := copyPair(p)
+	stored.ModifyIndex = m.index
+	stored.CreateIndex = m.index
+	if old, ok := m.data[p.Key]; ok {
+		stored.CreateIndex = old.CreateIndex
+	}
+	m.data[p.Key] = stored
+}
+
+func (m *memoryKV) Delete(key string, w *api.WriteOptions) (*api.WriteMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data[key]; ok {
+		m.index++
+		delete(m.data, key)
+	}
+	return &api.WriteMeta{}, nil
+}
+
+func (m *memoryKV) DeleteTree(prefix string, w *api.WriteOptions) (*api.WriteMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k := range m.data {
+		if strings.HasPrefix(k, prefix) {
+			m.index++
+			delete(m.data, k)
+		}
+	}
+	return &api.WriteMeta{}, nil
+}
+
+func (m *memoryKV) CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.matches(p) {
+		return false, &api.WriteMeta{}, nil
+	}
+	m.put(p)
+	return true, &api.WriteMeta{}, nil
+}
+
+func (m *memoryKV) DeleteCAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p.ModifyIndex == 0 || !m.matches(p) {
+		return false, &api.WriteMeta{}, nil
+	}
+	m.index++
+	delete(m.data, p.Key)
+	return true, &api.WriteMeta{}, nil
+}
+
+// matches reports whether p.ModifyIndex is the current index of p.Key,
+// where index 0 means the key must not exist yet.
+func (m *memoryKV) matches(p *api.KVPair) bool {
+	old, ok := m.data[p.Key]
+	if p.ModifyIndex == 0 {
+		return !ok
+	}
+	return ok && old.ModifyIndex == p.ModifyIndex
+}
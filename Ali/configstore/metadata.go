@@ -0,0 +1,114 @@
+package configstore
+
+import (
+	tracer "Ali/tracer"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"golang.org/x/net/context"
+	"time"
+)
+
+// Metadata is managed by the store and saved together with every version.
+type Metadata struct {
+	CreatedAt time.Time `json:"createdAt"`
+	// CreatedBy is whatever the client sent in the X-User header. Requests
+	// are not authenticated, so it is informational and must not be used
+	// for access decisions.
+	CreatedBy   string   `json:"createdBy,omitempty"`
+	Parent      string   `json:"parent,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Hash        string   `json:"hash"`
+}
+
+type changeKey struct{}
+
+type change struct {
+	by          string
+	description string
+	tags        []string
+}
+
+// WithChange returns a context carrying who makes a change, why and the
+// tags to put on the version, recorded in the metadata of versions created
+// with it.
+func WithChange(ctx context.Context, by string, description string, tags []string) context.Context {
+	return context.WithValue(ctx, changeKey{}, change{by: by, description: description, tags: tags})
+}
+
+func contentHash(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// newMetadata builds the metadata of a new version. A parent already set by
+// the caller (restore, patch) is kept, otherwise the latest version under
+// the same id is the parent.
+func (cs *ConfigStore) newMetadata(ctx context.Context, current *Metadata, content interface{}, latest func() (string, error)) (*Metadata, error) {
+	span := tracer.StartSpanFromContext(ctx, "newMetadata")
+	defer span.Finish()
+	hash, err := contentHash(content)
+	if err != nil {
+		return nil, err
+	}
+	meta := &Metadata{CreatedAt: time.Now().UTC(), Hash: hash}
+	if c, ok := ctx.Value(changeKey{}).(change); ok {
+		meta.CreatedBy = c.by
+		meta.Description = c.description
+		meta.Tags = c.tags
+	}
+	if len(meta.Tags) == 0 && current != nil {
+		meta.Tags = current.Tags
+	}
+	if current != nil && current.Parent != "" {
+		meta.Parent = current.Parent
+		return meta, nil
+	}
+	if latest != nil {
+		parent, err := latest()
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		meta.Parent = parent
+	}
+	return meta, nil
+}
+
+// configMetadata builds the metadata of a new config version and points
+// DerivedFrom at the same parent.
+func (cs *ConfigStore) configMetadata(ctx context.Context, config *Config, withParent bool) (*Metadata, error) {
+	var latest func() (string, error)
+	if withParent {
+		latest = func() (string, error) {
+			return cs.lastConfigVersion(ctx, config.Id)
+		}
+	}
+	meta, err := cs.newMetadata(ctx, config.Meta, config.Entries, latest)
+	if err != nil {
+		return nil, err
+	}
+	config.DerivedFrom = meta.Parent
+	return meta, nil
+}
+
+// groupMetadata is configMetadata for groups.
+func (cs *ConfigStore) groupMetadata(ctx context.Context, group *Group, withParent bool) (*Metadata, error) {
+	var latest func() (string, error)
+	if withParent {
+		latest = func() (string, error) {
+			return cs.lastGroupVersion(ctx, group.Id)
+		}
+	}
+	meta, err := cs.newMetadata(ctx, group.Meta, group.Config, latest)
+	if err != nil {
+		return nil, err
+	}
+	group.DerivedFrom = meta.Parent
+	return meta, nil
+}
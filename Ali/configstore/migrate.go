@@ -0,0 +1,70 @@
+package configstore
+
+import (
+	tracer "Ali/tracer"
+	"encoding/json"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/net/context"
+)
+
+// migrateKeys moves config, group and trash pairs written with an older key
+// layout to the key the current layout gives them. The label index of
+// moved groups is rebuilt by the reindex migration that follows.
+func (cs *ConfigStore) migrateKeys(ctx context.Context) error {
+	span := tracer.StartSpanFromContext(ctx, "migrateKeys")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+	kv := cs.kv
+
+	for _, prefix := range []string{"config", "group", "trash"} {
+		data, _, err := kv.List(prefix, nil)
+		if err != nil {
+			return err
+		}
+		for _, pair := range data {
+			key := cs.currentKey(ctx, prefix, pair)
+			if key == "" || key == pair.Key {
+				continue
+			}
+			if _, err := cs.create(key, pair.Value); err != nil {
+				return err
+			}
+			ok, _, err := kv.DeleteCAS(pair, nil)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				// written again meanwhile, keep both and let the next run pick it up
+				kv.Delete(key, nil)
+			}
+		}
+	}
+	return nil
+}
+
+// currentKey returns the key pair belongs under in the current layout.
+// Pairs it does not recognise get an empty key and are left alone.
+func (cs *ConfigStore) currentKey(ctx context.Context, prefix string, pair *api.KVPair) string {
+	switch prefix {
+	case "config":
+		config := &Config{}
+		if json.Unmarshal(pair.Value, config) != nil || config.Id == "" {
+			return ""
+		}
+		return configKeyVersion(ctx, config.Id, config.Version)
+	case "group":
+		group := &Group{}
+		if json.Unmarshal(pair.Value, group) != nil || group.Id == "" {
+			return ""
+		}
+		return configKeyGroupVersion(ctx, group.Id, group.Version)
+	}
+	item := &TrashItem{}
+	if json.Unmarshal(pair.Value, item) != nil || item.Id == "" {
+		return ""
+	}
+	if item.Kind != GroupKind {
+		item.Kind = ConfigKind
+	}
+	return trashKey(ctx, item.Kind, item.Id, item.Version, item.DeletedAt)
+}
@@ -0,0 +1,231 @@
+package configstore
+
+import (
+	tracer "Ali/tracer"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/net/context"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migration changes stored data from schema version-1 to version. Every
+// migration can be run again after it was interrupted.
+type migration struct {
+	version int
+	name    string
+	run     func(cs *ConfigStore, ctx context.Context) error
+}
+
+// migrations in the order they are applied. New migrations are appended
+// with the next version, applied ones are never changed.
+var migrations = []migration{
+	{1, "drop root idempotency keys", (*ConfigStore).dropRootIdempotencyKeys},
+	{2, "escape key segments", (*ConfigStore).migrateKeys},
+	{3, "backfill state and metadata", (*ConfigStore).backfillMetadata},
+	{4, "reindex group labels", (*ConfigStore).reindexGroupLabels},
+	{5, "index entries, values and references", (*ConfigStore).indexAllEntries},
+}
+
+// SchemaVersion returns the version of the last migration applied to the
+// store, 0 for data that was never migrated.
+func (cs *ConfigStore) SchemaVersion(ctx context.Context) (int, error) {
+	span := tracer.StartSpanFromContext(ctx, "SchemaVersion")
+	defer span.Finish()
+	version, _, err := cs.schemaVersion()
+	return version, err
+}
+
+func (cs *ConfigStore) schemaVersion() (int, uint64, error) {
+	pair, _, err := cs.kv.Get(schemaVersion, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if pair == nil {
+		return 0, 0, nil
+	}
+	version, err := strconv.Atoi(string(pair.Value))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid schema version %q", pair.Value)
+	}
+	return version, pair.ModifyIndex, nil
+}
+
+// Migrate applies the migrations newer than the stored schema version in
+// order and records the version after each one, so an interrupted run
+// continues where it stopped. Instances starting together may run the same
+// migration; the one that records it second moves on. It returns the names
+// of the migrations this call recorded.
+func (cs *ConfigStore) Migrate(ctx context.Context) ([]string, error) {
+	span := tracer.StartSpanFromContext(ctx, "Migrate")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	applied := []string{}
+	for _, m := range migrations {
+		current, index, err := cs.schemaVersion()
+		if err != nil {
+			return applied, err
+		}
+		if m.version <= current {
+			continue
+		}
+		if err := m.run(cs, ctx); err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		p := &api.KVPair{Key: schemaVersion, Value: []byte(strconv.Itoa(m.version)), ModifyIndex: index}
+		ok, _, err := cs.kv.CAS(p, nil)
+		if err != nil {
+			return applied, err
+		}
+		if !ok {
+			// another instance migrating at the same time may have
+			// recorded it first
+			recorded, _, err := cs.schemaVersion()
+			if err != nil {
+				return applied, err
+			}
+			if recorded < m.version {
+				return applied, fmt.Errorf("migration %d (%s): %w", m.version, m.name, ErrPreconditionFailed)
+			}
+			continue
+		}
+		applied = append(applied, m.name)
+	}
+	return applied, nil
+}
+
+// dropRootIdempotencyKeys removes the bare request ids the first versions
+// of the service stored at the root of the key space. They carry no
+// response that could be replayed. The key space may be shared with other
+// applications, so only keys that are a uuid with an empty value are
+// removed.
+func (cs *ConfigStore) dropRootIdempotencyKeys(ctx context.Context) error {
+	span := tracer.StartSpanFromContext(ctx, "dropRootIdempotencyKeys")
+	defer span.Finish()
+	keys, _, err := cs.kv.Keys("", "/", nil)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, err := uuid.Parse(key); err != nil {
+			continue
+		}
+		pair, _, err := cs.kv.Get(key, nil)
+		if err != nil {
+			return err
+		}
+		if pair == nil || len(pair.Value) != 0 {
+			continue
+		}
+		ok, _, err := cs.kv.DeleteCAS(pair, nil)
+		if err != nil {
+			return err
+		}
+		if ok {
+			log.Printf("removed root idempotency key %s\n", key)
+		}
+	}
+	return nil
+}
+
+// backfillMetadata marks versions written before drafts existed as
+// published and gives versions without metadata their hash and parent.
+// A derivedFrom written by restore is kept as the parent.
+// The creation time of those versions is unknown and left empty.
+func (cs *ConfigStore) backfillMetadata(ctx context.Context) error {
+	span := tracer.StartSpanFromContext(ctx, "backfillMetadata")
+	defer span.Finish()
+	for _, prefix := range []string{all, allG} {
+		data, _, err := cs.kv.List(prefix, nil)
+		if err != nil {
+			return err
+		}
+		type stored struct {
+			pair    *api.KVPair
+			value   map[string]json.RawMessage
+			version string
+		}
+		byId := map[string][]*stored{}
+		for _, pair := range data {
+			id, version, ok := splitKey(strings.TrimPrefix(pair.Key, prefix))
+			if !ok {
+				continue
+			}
+			value := map[string]json.RawMessage{}
+			if err := json.Unmarshal(pair.Value, &value); err != nil {
+				return err
+			}
+			byId[id] = append(byId[id], &stored{pair: pair, value: value, version: version})
+		}
+		for _, versions := range byId {
+			sort.SliceStable(versions, func(i, j int) bool {
+				return compareVersions(versions[i].version, versions[j].version) < 0
+			})
+			for i, s := range versions {
+				_, hasState := s.value["state"]
+				_, hasMeta := s.value["meta"]
+				if hasState && hasMeta {
+					continue
+				}
+				if !hasState {
+					s.value["state"], _ = json.Marshal(PublishedState)
+				}
+				if !hasMeta {
+					content := s.value["entries"]
+					if prefix == allG {
+						content = s.value["config"]
+					}
+					meta := &Metadata{}
+					if meta.Hash, err = contentHash(content); err != nil {
+						return err
+					}
+					if i > 0 {
+						meta.Parent = versions[i-1].version
+					}
+					if derived, ok := s.value["derivedFrom"]; ok {
+						if err := json.Unmarshal(derived, &meta.Parent); err != nil {
+							return err
+						}
+					} else if meta.Parent != "" {
+						s.value["derivedFrom"], _ = json.Marshal(meta.Parent)
+					}
+					if s.value["meta"], err = json.Marshal(meta); err != nil {
+						return err
+					}
+				}
+				if err := cs.replace(s.pair.Key, s.value, s.pair.ModifyIndex); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// reindexGroupLabels rebuilds the label index of every group version.
+func (cs *ConfigStore) reindexGroupLabels(ctx context.Context) error {
+	span := tracer.StartSpanFromContext(ctx, "reindexGroupLabels")
+	defer span.Finish()
+	data, _, err := cs.kv.List(allG, nil)
+	if err != nil {
+		return err
+	}
+	for _, pair := range data {
+		group := &Group{}
+		if err := json.Unmarshal(pair.Value, group); err != nil {
+			return err
+		}
+		if err := cs.unindexGroupLabels(ctx, group.Id, group.Version); err != nil {
+			return err
+		}
+		if err := cs.indexGroupLabels(ctx, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
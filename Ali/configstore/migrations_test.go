@@ -0,0 +1,187 @@
+package configstore
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/net/context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// seedLegacy writes pairs the way the first versions of the service did:
+// unescaped key segments, no state or metadata, no indexes and trash
+// items without a deletion time in their key.
+func seedLegacy(t *testing.T, cs *ConfigStore) {
+	t.Helper()
+	deletedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	trashed, err := json.Marshal(&TrashItem{Kind: ConfigKind, Id: "old app", Version: "0.1.0", DeletedAt: deletedAt, Value: json.RawMessage(`{"id":"old app","version":"0.1.0","entries":{}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs := []*api.KVPair{
+		{Key: "4a9cd1a2-5bd4-4fb5-8a43-ec6a3d1e0b71"},
+		{Key: "7f1e2c55-7f0e-4c3e-9b1a-2d2f7c9a6e10", Value: []byte("kept")},
+		{Key: "not-a-uuid"},
+		{Key: "config/old app/1.0.0", Value: []byte(`{"id":"old app","version":"1.0.0","entries":{"color":"red"}}`)},
+		{Key: "config/old app/1.1.0", Value: []byte(`{"id":"old app","version":"1.1.0","entries":{"color":"blue"}}`)},
+		{Key: "config/old app/1.2.0", Value: []byte(`{"id":"old app","version":"1.2.0","entries":{"color":"red"},"derivedFrom":"1.0.0"}`)},
+		{Key: "group/g/1.0.0", Value: []byte(`{"id":"g","version":"1.0.0","config":[{"entries":{"size":"xl"}},{"ref":{"id":"old app","version":"1.1.0"}}]}`)},
+		{Key: "trash/config/old app/0.1.0", Value: trashed},
+	}
+	for _, p := range pairs {
+		if _, err := cs.kv.Put(p, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	cs := NewInMemory()
+	seedLegacy(t, cs)
+
+	applied, err := cs.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("applied %v, want all %d migrations", applied, len(migrations))
+	}
+	if version, err := cs.SchemaVersion(ctx); err != nil || version != migrations[len(migrations)-1].version {
+		t.Errorf("schema version %d, %v", version, err)
+	}
+
+	t.Run("root keys", func(t *testing.T) {
+		tests := []struct {
+			key  string
+			kept bool
+		}{
+			{"4a9cd1a2-5bd4-4fb5-8a43-ec6a3d1e0b71", false},
+			{"7f1e2c55-7f0e-4c3e-9b1a-2d2f7c9a6e10", true},
+			{"not-a-uuid", true},
+		}
+		for _, tt := range tests {
+			pair, _, err := cs.kv.Get(tt.key, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (pair != nil) != tt.kept {
+				t.Errorf("%s kept %v, want %v", tt.key, pair != nil, tt.kept)
+			}
+		}
+	})
+
+	t.Run("state and metadata", func(t *testing.T) {
+		tests := []struct {
+			version string
+			parent  string
+		}{
+			{"1.0.0", ""},
+			{"1.1.0", "1.0.0"},
+			{"1.2.0", "1.0.0"},
+		}
+		for _, tt := range tests {
+			config, err := cs.GetConf(ctx, "old app", tt.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.State != PublishedState || config.Meta == nil || config.Meta.Hash == "" {
+				t.Errorf("%s: state %q, meta %+v", tt.version, config.State, config.Meta)
+				continue
+			}
+			if config.Meta.Parent != tt.parent || config.DerivedFrom != tt.parent {
+				t.Errorf("%s: parent %q, derivedFrom %q, want %q", tt.version, config.Meta.Parent, config.DerivedFrom, tt.parent)
+			}
+		}
+	})
+
+	t.Run("indexes", func(t *testing.T) {
+		tests := []struct {
+			query EntryQuery
+			want  []string
+		}{
+			{EntryQuery{Key: "color", Value: "red"}, []string{"config old app@1.0.0", "config old app@1.2.0"}},
+			{EntryQuery{Value: "xl"}, []string{"group g@1.0.0"}},
+			{EntryQuery{Value: "blue"}, []string{"config old app@1.1.0", "group g@1.0.0"}},
+		}
+		for _, tt := range tests {
+			hits, err := cs.SearchEntries(ctx, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, hit := range hits {
+				got = append(got, hit.Kind+" "+hit.Id+"@"+hit.Version)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%+v found %v, want %v", tt.query, got, tt.want)
+			}
+		}
+		config, err := cs.GetConf(ctx, "old app", "1.1.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cs.Delete(ctx, config.Id, config.Version, config.Index); !errors.Is(err, ErrReferenced) {
+			t.Errorf("delete referenced version: got %v, want %v", err, ErrReferenced)
+		}
+	})
+
+	t.Run("trash", func(t *testing.T) {
+		if pair, _, err := cs.kv.Get("trash/config/old app/0.1.0", nil); err != nil || pair != nil {
+			t.Errorf("trash item left under its old key: %v", err)
+		}
+		items, err := cs.ListTrash(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 1 || items[0].Id != "old app" || items[0].Version != "0.1.0" {
+			t.Fatalf("trash holds %+v", items)
+		}
+		if _, err := cs.RestoreTrashedConfig(ctx, "old app", "0.1.0"); err != nil {
+			t.Errorf("restore migrated trash item: %v", err)
+		}
+	})
+
+	applied, err = cs.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("second run applied %v", applied)
+	}
+}
+
+// racingKV records every schema version just before the check-and-set that
+// would record it, as another instance migrating at the same time would.
+type racingKV struct {
+	kvBackend
+}
+
+func (kv racingKV) CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	if p.Key == schemaVersion {
+		if _, err := kv.kvBackend.Put(p, nil); err != nil {
+			return false, nil, err
+		}
+	}
+	return kv.kvBackend.CAS(p, q)
+}
+
+func TestMigrateConcurrently(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemory()
+	seedLegacy(t, store)
+	racing := &ConfigStore{kv: racingKV{store.kv}}
+
+	applied, err := racing.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("recorded %v that the other instance recorded", applied)
+	}
+	if version, err := store.SchemaVersion(ctx); err != nil || version != migrations[len(migrations)-1].version {
+		t.Errorf("schema version %d, %v", version, err)
+	}
+}
@@ -1,17 +1,40 @@
 package configstore
 
+import "time"
+
 type Config struct {
 	Id      string            `json:"id"`
 	Version string            `json:"version"`
 	Entries map[string]string `json:"entries"`
+	State   string            `json:"state,omitempty"`
+	Meta    *Metadata         `json:"meta,omitempty"`
+	// DerivedFrom mirrors Meta.Parent for clients written against the
+	// restore API before versions carried metadata.
+	DerivedFrom string `json:"derivedFrom,omitempty"`
+	Index       uint64 `json:"-"`
 }
 
 type ConfigG struct {
 	Entries map[string]string `json:"entries"`
+	Ref     *ConfigRef        `json:"ref,omitempty"`
 }
 
 type Group struct {
 	Version string     `json:"version"`
 	Id      string     `json:"id"`
 	Config  []*ConfigG `json:"config"`
+	State   string     `json:"state,omitempty"`
+	Meta    *Metadata  `json:"meta,omitempty"`
+	// DerivedFrom mirrors Meta.Parent, see Config.
+	DerivedFrom string `json:"derivedFrom,omitempty"`
+	Index       uint64 `json:"-"`
+}
+
+type IdempotentResponse struct {
+	Status      int                 `json:"status"`
+	Header      map[string][]string `json:"header"`
+	Body        []byte              `json:"body"`
+	Fingerprint string              `json:"fingerprint"`
+	ExpiresAt   time.Time           `json:"expiresAt"`
+	Pending     bool                `json:"pending,omitempty"`
 }
@@ -0,0 +1,236 @@
+package configstore
+
+import (
+	tracer "Ali/tracer"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/net/context"
+	"sort"
+	"strings"
+	"time"
+)
+
+// List orders.
+const (
+	SortById      = "id"
+	SortByVersion = "version"
+	SortByCreated = "created"
+)
+
+// memberOrder is the order of the cursors of group member pages, the order
+// the members are stored in.
+const memberOrder = "member"
+
+// Page sizes. A zero limit returns DefaultPageLimit items, larger limits
+// than MaxPageLimit are rejected.
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+// ListOptions select one page of a listing.
+type ListOptions struct {
+	Limit int
+	After string
+	Sort  string
+}
+
+// cursor is the position of the last item of a page in the order it was
+// listed in, sent to clients as opaque base64.
+type cursor struct {
+	Sort    string    `json:"sort"`
+	Id      string    `json:"id"`
+	Version string    `json:"version"`
+	Created time.Time `json:"created"`
+	// Member is the position of a group member in a page of members.
+	Member int `json:"member,omitempty"`
+}
+
+func (c *cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string, order string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q", ErrInvalidCursor, s)
+	}
+	c := &cursor{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%w %q", ErrInvalidCursor, s)
+	}
+	if c.Sort != order {
+		return nil, fmt.Errorf("%w %q, it was returned for sort %q", ErrInvalidCursor, s, c.Sort)
+	}
+	return c, nil
+}
+
+func cursorLess(order string) (func(a, b *cursor) bool, error) {
+	byId := func(a, b *cursor) bool {
+		if a.Id != b.Id {
+			return a.Id < b.Id
+		}
+		return compareVersions(a.Version, b.Version) < 0
+	}
+	switch order {
+	case "", SortById:
+		return byId, nil
+	case SortByVersion:
+		return func(a, b *cursor) bool {
+			if c := compareVersions(a.Version, b.Version); c != 0 {
+				return c < 0
+			}
+			return a.Id < b.Id
+		}, nil
+	case SortByCreated:
+		return func(a, b *cursor) bool {
+			if !a.Created.Equal(b.Created) {
+				return a.Created.Before(b.Created)
+			}
+			return byId(a, b)
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid sort %q, expected %s, %s or %s", order, SortById, SortByVersion, SortByCreated)
+}
+
+func pageLimit(limit int) (int, error) {
+	switch {
+	case limit == 0:
+		return DefaultPageLimit, nil
+	case limit < 0 || limit > MaxPageLimit:
+		return 0, fmt.Errorf("%w %d, expected 1 to %d", ErrInvalidLimit, limit, MaxPageLimit)
+	}
+	return limit, nil
+}
+
+// PageMembers returns the page of group members opts selects and the
+// cursor of the next page, empty on the last one. Members keep the order
+// of the group, so opts.Sort must be empty.
+func PageMembers(configs []*ConfigG, opts ListOptions) ([]*ConfigG, string, error) {
+	if opts.Sort != "" {
+		return nil, "", fmt.Errorf("invalid sort %q, group members are listed in their stored order", opts.Sort)
+	}
+	limit, err := pageLimit(opts.Limit)
+	if err != nil {
+		return nil, "", err
+	}
+	start := 0
+	if opts.After != "" {
+		after, err := decodeCursor(opts.After, memberOrder)
+		if err != nil {
+			return nil, "", err
+		}
+		if after.Member < 0 {
+			return nil, "", fmt.Errorf("%w %q", ErrInvalidCursor, opts.After)
+		}
+		start = after.Member + 1
+	}
+	if start > len(configs) {
+		start = len(configs)
+	}
+	if len(configs)-start <= limit {
+		return configs[start:], "", nil
+	}
+	end := start + limit
+	next := &cursor{Sort: memberOrder, Member: end - 1}
+	return configs[start:end], next.encode(), nil
+}
+
+// page returns the pairs under prefix on the page opts selects and the
+// cursor of the next page, empty on the last one. Only the ordering by
+// creation time needs the values of every version, the other orders are
+// taken from the keys and just the pairs on the page are read.
+func (cs *ConfigStore) page(ctx context.Context, prefix string, opts ListOptions) ([]*api.KVPair, string, error) {
+	span := tracer.StartSpanFromContext(ctx, "page")
+	defer span.Finish()
+	kv := cs.kv
+	if opts.Sort == "" {
+		opts.Sort = SortById
+	}
+	less, err := cursorLess(opts.Sort)
+	if err != nil {
+		return nil, "", err
+	}
+	if opts.Limit, err = pageLimit(opts.Limit); err != nil {
+		return nil, "", err
+	}
+	var after *cursor
+	if opts.After != "" {
+		if after, err = decodeCursor(opts.After, opts.Sort); err != nil {
+			return nil, "", err
+		}
+	}
+
+	type item struct {
+		cursor *cursor
+		key    string
+		pair   *api.KVPair
+	}
+	items := []*item{}
+	if opts.Sort == SortByCreated {
+		data, _, err := kv.List(prefix, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, pair := range data {
+			id, version, ok := splitKey(strings.TrimPrefix(pair.Key, prefix))
+			if !ok {
+				continue
+			}
+			c := &cursor{Sort: opts.Sort, Id: id, Version: version}
+			info := versionInfo{}
+			if json.Unmarshal(pair.Value, &info) == nil && info.Meta != nil {
+				c.Created = info.Meta.CreatedAt
+			}
+			items = append(items, &item{cursor: c, key: pair.Key, pair: pair})
+		}
+	} else {
+		keys, _, err := kv.Keys(prefix, "", nil)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, key := range keys {
+			id, version, ok := splitKey(strings.TrimPrefix(key, prefix))
+			if !ok {
+				continue
+			}
+			items = append(items, &item{cursor: &cursor{Sort: opts.Sort, Id: id, Version: version}, key: key})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return less(items[i].cursor, items[j].cursor)
+	})
+
+	if after != nil {
+		start := sort.Search(len(items), func(i int) bool {
+			return less(after, items[i].cursor)
+		})
+		items = items[start:]
+	}
+	next := ""
+	if len(items) > opts.Limit {
+		items = items[:opts.Limit]
+		next = items[len(items)-1].cursor.encode()
+	}
+
+	for _, it := range items {
+		if it.pair == nil {
+			if it.pair, _, err = kv.Get(it.key, nil); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	pairs := []*api.KVPair{}
+	for _, it := range items {
+		if it.pair == nil {
+			// deleted since the keys were listed
+			continue
+		}
+		pairs = append(pairs, it.pair)
+	}
+	return pairs, next, nil
+}
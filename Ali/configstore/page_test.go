@@ -0,0 +1,158 @@
+package configstore
+
+import (
+	"errors"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/net/context"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// seedPages stores versions 1.0.0 to 1.0.{n-1} of two configs, the
+// versions of the first config created first.
+func seedPages(t *testing.T, cs *ConfigStore, n int) []string {
+	t.Helper()
+	ctx := context.Background()
+	ids := []string{}
+	for i := 0; i < 2; i++ {
+		config, err := cs.Post(ctx, &Config{Version: "1.0.0", Entries: map[string]string{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for v := 1; v < n; v++ {
+			_, err := cs.AddConfigVersion(ctx, &Config{Id: config.Id, Version: "1.0." + strconv.Itoa(v), Entries: map[string]string{}})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		ids = append(ids, config.Id)
+	}
+	return ids
+}
+
+func TestPaging(t *testing.T) {
+	ctx := context.Background()
+	cs := NewInMemory()
+	ids := seedPages(t, cs, 3)
+	lo, hi := ids[0], ids[1]
+	if hi < lo {
+		lo, hi = hi, lo
+	}
+
+	tests := []struct {
+		sort  string
+		limit int
+		want  []string
+	}{
+		{SortById, 2, []string{lo + "@1.0.0", lo + "@1.0.1", lo + "@1.0.2", hi + "@1.0.0", hi + "@1.0.1", hi + "@1.0.2"}},
+		{SortByVersion, 4, []string{lo + "@1.0.0", hi + "@1.0.0", lo + "@1.0.1", hi + "@1.0.1", lo + "@1.0.2", hi + "@1.0.2"}},
+		{SortByCreated, 5, []string{ids[0] + "@1.0.0", ids[0] + "@1.0.1", ids[0] + "@1.0.2", ids[1] + "@1.0.0", ids[1] + "@1.0.1", ids[1] + "@1.0.2"}},
+		{"", 0, []string{lo + "@1.0.0", lo + "@1.0.1", lo + "@1.0.2", hi + "@1.0.0", hi + "@1.0.1", hi + "@1.0.2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			got := []string{}
+			pages := 0
+			opts := ListOptions{Limit: tt.limit, Sort: tt.sort}
+			for {
+				configs, next, err := cs.GetAll(ctx, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.limit > 0 && len(configs) > tt.limit {
+					t.Fatalf("page of %d configs, limit %d", len(configs), tt.limit)
+				}
+				for _, c := range configs {
+					got = append(got, c.Id+"@"+c.Version)
+				}
+				pages++
+				if next == "" {
+					break
+				}
+				opts.After = next
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			wantPages := 1
+			if tt.limit > 0 {
+				wantPages = (len(tt.want) + tt.limit - 1) / tt.limit
+			}
+			if pages != wantPages {
+				t.Errorf("listed in %d pages, want %d", pages, wantPages)
+			}
+		})
+	}
+}
+
+func TestPagingErrors(t *testing.T) {
+	ctx := context.Background()
+	cs := NewInMemory()
+	seedPages(t, cs, 3)
+	_, next, err := cs.GetAll(ctx, ListOptions{Limit: 1, Sort: SortByVersion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		opts ListOptions
+		want error
+	}{
+		{"negative limit", ListOptions{Limit: -1}, ErrInvalidLimit},
+		{"limit above maximum", ListOptions{Limit: MaxPageLimit + 1}, ErrInvalidLimit},
+		{"garbage cursor", ListOptions{After: "not a cursor"}, ErrInvalidCursor},
+		{"cursor of another sort", ListOptions{After: next, Sort: SortById}, ErrInvalidCursor},
+		{"cursor of the default sort", ListOptions{After: next}, ErrInvalidCursor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := cs.GetAll(ctx, tt.opts); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+	if _, _, err := cs.GetAll(ctx, ListOptions{Sort: "size"}); err == nil {
+		t.Error("unknown sort accepted")
+	}
+}
+
+// countingKV counts the reads of the pairs of a store.
+type countingKV struct {
+	kvBackend
+	gets, lists int
+}
+
+func (kv *countingKV) Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	kv.gets++
+	return kv.kvBackend.Get(key, q)
+}
+
+func (kv *countingKV) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	kv.lists++
+	return kv.kvBackend.List(prefix, q)
+}
+
+func TestPagingReadsOnlyThePage(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemory()
+	seedPages(t, store, 30)
+	tests := []struct {
+		opts  ListOptions
+		count int
+	}{
+		{ListOptions{}, 60},
+		{ListOptions{Limit: 20}, 20},
+		{ListOptions{Limit: 20, Sort: SortByVersion}, 20},
+	}
+	for _, tt := range tests {
+		kv := &countingKV{kvBackend: store.kv}
+		configs, _, err := (&ConfigStore{kv: kv}).GetAll(ctx, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(configs) != tt.count || kv.gets != tt.count || kv.lists != 0 {
+			t.Errorf("%+v: %d configs read with %d gets and %d lists, want %d gets and no list", tt.opts, len(configs), kv.gets, kv.lists, tt.count)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package configstore
+
+import (
+	"encoding/json"
+	"errors"
+	"golang.org/x/net/context"
+	"reflect"
+	"testing"
+)
+
+func equalJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("invalid result %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("invalid expectation %s: %v", want, err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// Examples from RFC 6902 appendix A.
+func TestApplyJSONPatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{"A.1 add object member", `{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz","value":"qux"}]`,
+			`{"baz":"qux","foo":"bar"}`},
+		{"A.2 add array element", `{"foo":["bar","baz"]}`,
+			`[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			`{"foo":["bar","qux","baz"]}`},
+		{"A.3 remove object member", `{"baz":"qux","foo":"bar"}`,
+			`[{"op":"remove","path":"/baz"}]`,
+			`{"foo":"bar"}`},
+		{"A.4 remove array element", `{"foo":["bar","qux","baz"]}`,
+			`[{"op":"remove","path":"/foo/1"}]`,
+			`{"foo":["bar","baz"]}`},
+		{"A.5 replace value", `{"baz":"qux","foo":"bar"}`,
+			`[{"op":"replace","path":"/baz","value":"boo"}]`,
+			`{"baz":"boo","foo":"bar"}`},
+		{"A.6 move value", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{"A.7 move array element", `{"foo":["all","grass","cows","eat"]}`,
+			`[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			`{"foo":["all","cows","eat","grass"]}`},
+		{"A.8 test success", `{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{"A.10 add nested member", `{"foo":"bar"}`,
+			`[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			`{"foo":"bar","child":{"grandchild":{}}}`},
+		{"A.11 ignore unrecognized elements", `{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+			`{"foo":"bar","baz":"qux"}`},
+		{"A.14 ~ escape ordering", `{"/":9,"~1":10}`,
+			`[{"op":"test","path":"/~01","value":10}]`,
+			`{"/":9,"~1":10}`},
+		{"A.16 add array value", `{"foo":["bar"]}`,
+			`[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			`{"foo":["bar",["abc","def"]]}`},
+		{"copy value", `{"foo":{"bar":"baz"}}`,
+			`[{"op":"copy","from":"/foo","path":"/qux"},{"op":"replace","path":"/qux/bar","value":"x"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"bar":"x"}}`},
+		{"replace with null", `{"foo":"bar"}`,
+			`[{"op":"replace","path":"/foo","value":null}]`,
+			`{"foo":null}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyJSONPatch([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			equalJSON(t, got, tt.want)
+		})
+	}
+}
+
+func TestApplyJSONPatchErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+	}{
+		{"A.9 test failure", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`},
+		{"A.12 add to nonexistent target", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`},
+		{"A.15 test number against string", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`},
+		{"remove missing member", `{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`},
+		{"array index out of range", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/2","value":"x"}]`},
+		{"leading zero index", `{"foo":["a","b"]}`, `[{"op":"remove","path":"/foo/01"}]`},
+		{"move into own child", `{"foo":{"bar":"baz"}}`, `[{"op":"move","from":"/foo","path":"/foo/bar"}]`},
+		{"missing value", `{"foo":"bar"}`, `[{"op":"add","path":"/baz"}]`},
+		{"unknown operation", `{"foo":"bar"}`, `[{"op":"merge","path":"/foo","value":1}]`},
+		{"invalid pointer", `{"foo":"bar"}`, `[{"op":"remove","path":"foo"}]`},
+		{"not a patch", `{"foo":"bar"}`, `{"op":"remove","path":"/foo"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := applyJSONPatch([]byte(tt.doc), []byte(tt.patch))
+			if !errors.Is(err, ErrInvalidPatch) {
+				t.Errorf("got %v, want %v", err, ErrInvalidPatch)
+			}
+		})
+	}
+}
+
+// Examples from RFC 7396 appendix A.
+func TestApplyMergePatch(t *testing.T) {
+	tests := []struct {
+		doc   string
+		patch string
+		want  string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.patch, func(t *testing.T) {
+			got, err := applyMergePatch([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			equalJSON(t, got, tt.want)
+		})
+	}
+}
+
+func TestPatchConfig(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name      string
+		mediatype string
+		patch     string
+		version   string
+		entries   map[string]string
+		state     string
+	}{
+		{"json patch", JSONPatchType, `[{"op":"replace","path":"/entries/a","value":"9"}]`,
+			"1.0.1", map[string]string{"a": "9", "b": "2"}, PublishedState},
+		{"merge patch", MergePatchType, `{"entries":{"b":null,"c":"3"}}`,
+			"1.0.1", map[string]string{"a": "1", "c": "3"}, PublishedState},
+		{"explicit version and state", MergePatchType, `{"version":"2.0.0","state":"draft"}`,
+			"2.0.0", map[string]string{"a": "1", "b": "2"}, DraftState},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := NewInMemory()
+			base, err := cs.Post(ctx, &Config{Version: "1.0.0", Entries: map[string]string{"a": "1", "b": "2"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := cs.PatchConfig(ctx, base.Id, base.Version, "", tt.mediatype, []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Version != tt.version || got.State != tt.state || !reflect.DeepEqual(got.Entries, tt.entries) {
+				t.Errorf("got %s %s %v, want %s %s %v", got.Version, got.State, got.Entries, tt.version, tt.state, tt.entries)
+			}
+			if got.Meta.Parent != base.Version || got.DerivedFrom != base.Version {
+				t.Errorf("parent %q, derivedFrom %q, want %q", got.Meta.Parent, got.DerivedFrom, base.Version)
+			}
+			latest, err := cs.GetConf(ctx, base.Id, LatestVersion)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.state == PublishedState; (latest.Version == got.Version) != want {
+				t.Errorf("latest is %s after patching %s version %s", latest.Version, tt.state, got.Version)
+			}
+		})
+	}
+}
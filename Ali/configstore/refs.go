@@ -0,0 +1,174 @@
+package configstore
+
+import (
+	tracer "Ali/tracer"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/net/context"
+	"strings"
+)
+
+// ConfigRef points a group member at a standalone config. Version is either
+// pinned or an alias or range such as latest that is resolved on every
+// read, Resolved tells which version was used.
+type ConfigRef struct {
+	Id       string `json:"id"`
+	Version  string `json:"version"`
+	Resolved string `json:"resolved,omitempty"`
+}
+
+// checkRefs rejects references to configs that do not exist and drops what
+// was resolved on read, only the reference itself is stored as it was
+// given. A published group with an alias or range keeps following it, the
+// stored document still never changes.
+func (cs *ConfigStore) checkRefs(ctx context.Context, group *Group) error {
+	span := tracer.StartSpanFromContext(ctx, "checkRefs")
+	defer span.Finish()
+	for _, c := range group.Config {
+		if c.Ref == nil {
+			continue
+		}
+		if c.Ref.Id == "" {
+			return fmt.Errorf("%w: config id is required", ErrInvalidReference)
+		}
+		if c.Ref.Version == "" {
+			c.Ref.Version = LatestVersion
+		}
+		_, err := cs.GetConf(ctx, c.Ref.Id, c.Ref.Version)
+		if errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("%w: config %s has no version %s", ErrInvalidReference, c.Ref.Id, c.Ref.Version)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	unresolveRefs(group)
+	return nil
+}
+
+func unresolveRefs(group *Group) {
+	for _, c := range group.Config {
+		if c.Ref != nil {
+			c.Entries = nil
+			c.Ref.Resolved = ""
+		}
+	}
+}
+
+// resolveRefs fills the entries of referenced members from their configs.
+// Members whose config is gone, which only happens when a group is put
+// back from the trash, are left without entries.
+func (cs *ConfigStore) resolveRefs(ctx context.Context, group *Group) error {
+	span := tracer.StartSpanFromContext(ctx, "resolveRefs")
+	defer span.Finish()
+	for _, c := range group.Config {
+		if c.Ref == nil {
+			continue
+		}
+		config, err := cs.GetConf(ctx, c.Ref.Id, c.Ref.Version)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		c.Entries = config.Entries
+		c.Ref.Resolved = config.Version
+	}
+	return nil
+}
+
+// indexGroupRefs records under index/refs/{config id}/{group id}/{version}
+// which group versions reference a config.
+func (cs *ConfigStore) indexGroupRefs(ctx context.Context, group *Group) error {
+	span := tracer.StartSpanFromContext(ctx, "indexGroupRefs")
+	defer span.Finish()
+	for _, c := range group.Config {
+		if c.Ref == nil {
+			continue
+		}
+		_, err := cs.kv.Put(&api.KVPair{Key: refIndexKey(ctx, c.Ref.Id, group.Id, group.Version)}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cs *ConfigStore) unindexGroupRefs(ctx context.Context, group *Group) error {
+	span := tracer.StartSpanFromContext(ctx, "unindexGroupRefs")
+	defer span.Finish()
+	for _, c := range group.Config {
+		if c.Ref == nil {
+			continue
+		}
+		_, err := cs.kv.Delete(refIndexKey(ctx, c.Ref.Id, group.Id, group.Version), nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// referencingGroups returns the group versions with a reference that
+// currently resolves to the given config version.
+func (cs *ConfigStore) referencingGroups(ctx context.Context, id string, version string) ([]*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "referencingGroups")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+	prefix := refIndexIdKey(ctx, id)
+	keys, _, err := cs.kv.Keys(prefix, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	groups := []*Group{}
+	for _, key := range keys {
+		groupId, groupVersion, ok := splitKey(strings.TrimPrefix(key, prefix))
+		if !ok {
+			continue
+		}
+		pair, _, err := cs.kv.Get(configKeyGroupVersion(ctx, groupId, groupVersion), nil)
+		if err != nil {
+			return nil, err
+		}
+		if pair == nil {
+			continue
+		}
+		group := &Group{}
+		if err := json.Unmarshal(pair.Value, group); err != nil {
+			return nil, err
+		}
+		for _, c := range group.Config {
+			if c.Ref == nil || c.Ref.Id != id {
+				continue
+			}
+			resolved, err := cs.resolveConfigVersion(ctx, id, c.Ref.Version)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if resolved == version {
+				groups = append(groups, group)
+				break
+			}
+		}
+	}
+	return groups, nil
+}
+
+// checkUnreferenced fails with ErrReferenced when a group reference
+// resolves to the config version.
+func (cs *ConfigStore) checkUnreferenced(ctx context.Context, id string, version string) error {
+	groups, err := cs.referencingGroups(ctx, id, version)
+	if err != nil {
+		return err
+	}
+	if len(groups) > 0 {
+		return fmt.Errorf("%w: config %s version %s is used by group %s version %s", ErrReferenced, id, version, groups[0].Id, groups[0].Version)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package configstore
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"testing"
+)
+
+func TestGroupRefs(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		state    string
+		ref      string
+		publish  bool
+		stored   string
+		resolved string
+	}{
+		{"published latest", PublishedState, LatestVersion, false, LatestVersion, "1.1.0"},
+		{"published range", PublishedState, "^1", false, "^1", "1.1.0"},
+		{"pinned", PublishedState, "1.0.0", false, "1.0.0", "1.0.0"},
+		{"draft latest", DraftState, LatestVersion, false, LatestVersion, "1.1.0"},
+		{"draft published later", DraftState, LatestVersion, true, LatestVersion, "1.1.0"},
+		{"empty version", PublishedState, "", false, LatestVersion, "1.1.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := NewInMemory()
+			config, err := cs.Post(ctx, &Config{Version: "1.0.0", Entries: map[string]string{"v": "1.0.0"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			group, err := cs.Group(ctx, &Group{Version: "1.0.0", State: tt.state, Config: []*ConfigG{{Ref: &ConfigRef{Id: config.Id, Version: tt.ref}}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.publish {
+				if group, err = cs.PublishGroup(ctx, group.Id, group.Version, group.Index); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := cs.AddConfigVersion(ctx, &Config{Id: config.Id, Version: "1.1.0", Entries: map[string]string{"v": "1.1.0"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := cs.GetGroup(ctx, group.Id, group.Version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ref := got.Config[0].Ref
+			if ref.Version != tt.stored || ref.Resolved != tt.resolved || got.Config[0].Entries["v"] != tt.resolved {
+				t.Errorf("ref %s resolved to %s with %v, want %s resolved to %s", ref.Version, ref.Resolved, got.Config[0].Entries, tt.stored, tt.resolved)
+			}
+		})
+	}
+}
+
+func TestGroupRefsMissingConfig(t *testing.T) {
+	ctx := context.Background()
+	cs := NewInMemory()
+	tests := []*ConfigRef{
+		{Version: LatestVersion},
+		{Id: "missing", Version: LatestVersion},
+	}
+	for _, ref := range tests {
+		_, err := cs.Group(ctx, &Group{Version: "1.0.0", Config: []*ConfigG{{Ref: ref}}})
+		if !errors.Is(err, ErrInvalidReference) {
+			t.Errorf("ref %+v: got %v, want %v", ref, err, ErrInvalidReference)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package configstore
+
+import (
+	tracer "Ali/tracer"
+	"golang.org/x/net/context"
+)
+
+// RestoreConfig copies an existing config version forward as a new version.
+// When as is empty the patch number of the latest version is bumped. The
+// state of the source is not copied, the copy is created like any new
+// version.
+func (cs *ConfigStore) RestoreConfig(ctx context.Context, id string, version string, as string) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "RestoreConfig")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	config, err := cs.GetConf(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	if as == "" {
+		latest, err := cs.lastConfigVersion(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if as, err = nextPatchVersion(latest); err != nil {
+			return nil, err
+		}
+	}
+	config.Meta = &Metadata{Parent: config.Version}
+	config.Version = as
+	config.State = ""
+	config.Index = 0
+	return cs.AddConfigVersion(ctx, config)
+}
+
+// RestoreGroup copies an existing group version forward as a new version.
+func (cs *ConfigStore) RestoreGroup(ctx context.Context, id string, version string, as string) (*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "RestoreGroup")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	group, err := cs.GetGroup(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	if as == "" {
+		latest, err := cs.lastGroupVersion(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if as, err = nextPatchVersion(latest); err != nil {
+			return nil, err
+		}
+	}
+	group.Meta = &Metadata{Parent: group.Version}
+	group.Version = as
+	group.State = ""
+	group.Index = 0
+	return cs.AddConfigGroupVersion(ctx, group)
+}
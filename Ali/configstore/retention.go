@@ -0,0 +1,167 @@
+package configstore
+
+import (
+	tracer "Ali/tracer"
+	"encoding/json"
+	"errors"
+	"golang.org/x/net/context"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy decides which old versions are pruned. A version is
+// removed only when it is outside the KeepLast newest published versions of
+// its id and was created more than KeepFor ago. Drafts, tagged versions and
+// config versions a group references are always kept. Versions with an
+// unknown creation time are kept only when KeepFor is set, a zero policy
+// keeps everything. Pruned versions go to the trash and can be restored
+// until it is purged.
+type RetentionPolicy struct {
+	KeepLast int
+	KeepFor  time.Duration
+}
+
+func (p RetentionPolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		KeepLast int    `json:"keepLast"`
+		KeepFor  string `json:"keepFor"`
+	}{p.KeepLast, p.KeepFor.String()})
+}
+
+func (p RetentionPolicy) enabled() bool {
+	return p.KeepLast > 0 || p.KeepFor > 0
+}
+
+type PrunedVersion struct {
+	Id      string `json:"id"`
+	Version string `json:"version"`
+}
+
+type PruneReport struct {
+	Policy  RetentionPolicy  `json:"policy"`
+	DryRun  bool             `json:"dryRun"`
+	Configs []*PrunedVersion `json:"configs"`
+	Groups  []*PrunedVersion `json:"groups"`
+}
+
+// versionInfo holds the fields of a stored config or group the policy
+// looks at.
+type versionInfo struct {
+	State string    `json:"state"`
+	Meta  *Metadata `json:"meta"`
+}
+
+// Prune removes the config and group versions the policy does not keep.
+// With dryRun set nothing is removed and the report lists what would be.
+func (cs *ConfigStore) Prune(ctx context.Context, policy RetentionPolicy, now time.Time, dryRun bool) (*PruneReport, error) {
+	span := tracer.StartSpanFromContext(ctx, "Prune")
+	defer span.Finish()
+	report := &PruneReport{Policy: policy, DryRun: dryRun, Configs: []*PrunedVersion{}, Groups: []*PrunedVersion{}}
+	if !policy.enabled() {
+		return report, nil
+	}
+
+	var err error
+	report.Configs, err = cs.prune(ctx, ConfigKind, all, policy, now, dryRun, nil)
+	if err != nil {
+		return nil, err
+	}
+	report.Groups, err = cs.prune(ctx, GroupKind, allG, policy, now, dryRun, cs.unindexGroupLabels)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (cs *ConfigStore) prune(ctx context.Context, kind string, prefix string, policy RetentionPolicy, now time.Time, dryRun bool, cleanup func(ctx context.Context, id string, version string) error) ([]*PrunedVersion, error) {
+	span := tracer.StartSpanFromContext(ctx, "prune")
+	defer span.Finish()
+	kv := cs.kv
+	data, _, err := kv.List(prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	type stored struct {
+		version string
+		semver  *semver
+		info    versionInfo
+		index   uint64
+		key     string
+	}
+	byId := make(map[string][]*stored)
+	ids := []string{}
+	for _, pair := range data {
+		id, version, ok := splitKey(strings.TrimPrefix(pair.Key, prefix))
+		if !ok {
+			continue
+		}
+		v, err := parseVersion(version)
+		if err != nil {
+			continue
+		}
+		s := &stored{version: version, semver: v, index: pair.ModifyIndex, key: pair.Key}
+		if err := json.Unmarshal(pair.Value, &s.info); err != nil {
+			continue
+		}
+		if _, ok := byId[id]; !ok {
+			ids = append(ids, id)
+		}
+		byId[id] = append(byId[id], s)
+	}
+	sort.Strings(ids)
+
+	pruned := []*PrunedVersion{}
+	for _, id := range ids {
+		versions := byId[id]
+		sort.Slice(versions, func(i, j int) bool {
+			return versions[i].semver.compare(versions[j].semver) > 0
+		})
+		published := 0
+		for _, s := range versions {
+			if s.info.State == DraftState {
+				continue
+			}
+			published++
+			if published <= policy.KeepLast {
+				continue
+			}
+			meta := s.info.Meta
+			if meta != nil && len(meta.Tags) > 0 {
+				continue
+			}
+			if policy.KeepFor > 0 {
+				if meta == nil || meta.CreatedAt.IsZero() || now.Sub(meta.CreatedAt) < policy.KeepFor {
+					continue
+				}
+			}
+			if kind == ConfigKind {
+				err := cs.checkUnreferenced(ctx, id, s.version)
+				if errors.Is(err, ErrReferenced) {
+					continue
+				}
+				if err != nil {
+					return pruned, err
+				}
+			}
+			if !dryRun {
+				err := cs.moveToTrash(ctx, kind, id, s.version, s.key, s.index)
+				if errors.Is(err, ErrPreconditionFailed) || errors.Is(err, ErrNotFound) {
+					// changed since it was listed, look at it next time
+					continue
+				}
+				if err != nil {
+					return pruned, err
+				}
+				if cleanup != nil {
+					if err := cleanup(ctx, id, s.version); err != nil {
+						return pruned, err
+					}
+				}
+			}
+			pruned = append(pruned, &PrunedVersion{Id: id, Version: s.version})
+		}
+	}
+	return pruned, nil
+}
@@ -0,0 +1,121 @@
+package configstore
+
+import (
+	"encoding/json"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/net/context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// seedRetention stores published versions 1.0.0 to 1.0.4 of one config, a
+// draft 1.0.5, a tagged 0.9.0 and an undated 0.1.0 written before versions
+// had metadata.
+func seedRetention(t *testing.T, cs *ConfigStore) string {
+	t.Helper()
+	ctx := context.Background()
+	config, err := cs.Post(ctx, &Config{Version: "1.0.0", Entries: map[string]string{"v": "0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []string{"1.0.1", "1.0.2", "1.0.3", "1.0.4"} {
+		if _, err := cs.AddConfigVersion(ctx, &Config{Id: config.Id, Version: version, Entries: map[string]string{"v": version}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cs.AddConfigVersion(ctx, &Config{Id: config.Id, Version: "1.0.5", State: DraftState, Entries: map[string]string{}}); err != nil {
+		t.Fatal(err)
+	}
+	tagged := WithChange(ctx, "", "", []string{"release"})
+	if _, err := cs.AddConfigVersion(tagged, &Config{Id: config.Id, Version: "0.9.0", Entries: map[string]string{}}); err != nil {
+		t.Fatal(err)
+	}
+	undated, err := json.Marshal(&Config{Id: config.Id, Version: "0.1.0", State: PublishedState, Meta: &Metadata{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.kv.Put(&api.KVPair{Key: configKeyVersion(ctx, config.Id, "0.1.0"), Value: undated}, nil); err != nil {
+		t.Fatal(err)
+	}
+	return config.Id
+}
+
+func prunedVersions(versions []*PrunedVersion) []string {
+	pruned := []string{}
+	for _, v := range versions {
+		pruned = append(pruned, v.Version)
+	}
+	return pruned
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	later := time.Now().Add(48 * time.Hour)
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		now    time.Time
+		want   []string
+	}{
+		{"disabled", RetentionPolicy{}, later, []string{}},
+		{"keep last published", RetentionPolicy{KeepLast: 2}, later, []string{"1.0.2", "1.0.1", "1.0.0", "0.1.0"}},
+		{"keep last beyond stored", RetentionPolicy{KeepLast: 10}, later, []string{}},
+		{"keep recent", RetentionPolicy{KeepFor: 24 * time.Hour}, time.Now(), []string{}},
+		{"keep recent and last", RetentionPolicy{KeepLast: 1, KeepFor: 24 * time.Hour}, later, []string{"1.0.3", "1.0.2", "1.0.1", "1.0.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := NewInMemory()
+			id := seedRetention(t, cs)
+
+			dry, err := cs.Prune(ctx, tt.policy, tt.now, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := prunedVersions(dry.Configs); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("dry run got %v, want %v", got, tt.want)
+			}
+			versions, err := cs.GetConfVersions(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(versions) != 8 {
+				t.Fatalf("dry run left %d of 8 versions", len(versions))
+			}
+
+			report, err := cs.Prune(ctx, tt.policy, tt.now, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := prunedVersions(report.Configs); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for _, version := range tt.want {
+				if _, err := cs.GetConf(ctx, id, version); err == nil {
+					t.Errorf("pruned %s is still stored", version)
+				}
+				if _, err := cs.RestoreTrashedConfig(ctx, id, version); err != nil {
+					t.Errorf("restore pruned %s: %v", version, err)
+				}
+			}
+		})
+	}
+}
+
+func TestPruneKeepsReferencedConfigs(t *testing.T) {
+	ctx := context.Background()
+	cs := NewInMemory()
+	id := seedRetention(t, cs)
+	_, err := cs.Group(ctx, &Group{Version: "1.0.0", Config: []*ConfigG{{Ref: &ConfigRef{Id: id, Version: "1.0.1"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := cs.Prune(ctx, RetentionPolicy{KeepLast: 2}, time.Now().Add(48*time.Hour), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := prunedVersions(report.Configs), []string{"1.0.2", "1.0.0", "0.1.0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
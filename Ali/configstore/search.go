@@ -0,0 +1,333 @@
+package configstore
+
+import (
+	tracer "Ali/tracer"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/net/context"
+	"net/url"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// Entry matching modes of a search.
+const (
+	MatchExact  = "exact"
+	MatchPrefix = "prefix"
+	MatchRegex  = "regex"
+)
+
+// EntryQuery selects entries by key and value. An empty Key or Value
+// matches any, Match applies to both. A regex only narrows the index when
+// it starts with ^ followed by literal text; a query that cannot be
+// narrowed by its key or its value is rejected.
+type EntryQuery struct {
+	Key   string
+	Value string
+	Match string
+}
+
+// EntryHit is one entry of a config or group version matching a search.
+type EntryHit struct {
+	Kind    string `json:"kind"`
+	Id      string `json:"id"`
+	Version string `json:"version"`
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+}
+
+// indexEntries adds the entries of a version to the inverted indexes kept
+// under index/entries/{key}/{value}/{kind}/{id}/{version} and, for searches
+// by value, index/values/{value}/{key}/{kind}/{id}/{version}.
+func (cs *ConfigStore) indexEntries(ctx context.Context, kind string, id string, version string, entries ...map[string]string) error {
+	span := tracer.StartSpanFromContext(ctx, "indexEntries")
+	defer span.Finish()
+	for _, e := range entries {
+		for k, v := range e {
+			_, err := cs.kv.Put(&api.KVPair{Key: entryIndexKey(ctx, k, v, kind, id, version)}, nil)
+			if err != nil {
+				return err
+			}
+			_, err = cs.kv.Put(&api.KVPair{Key: valueIndexKey(ctx, k, v, kind, id, version)}, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (cs *ConfigStore) unindexEntries(ctx context.Context, kind string, id string, version string, entries ...map[string]string) error {
+	span := tracer.StartSpanFromContext(ctx, "unindexEntries")
+	defer span.Finish()
+	for _, e := range entries {
+		for k, v := range e {
+			_, err := cs.kv.Delete(entryIndexKey(ctx, k, v, kind, id, version), nil)
+			if err != nil {
+				return err
+			}
+			_, err = cs.kv.Delete(valueIndexKey(ctx, k, v, kind, id, version), nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func groupEntries(group *Group) []map[string]string {
+	entries := make([]map[string]string, 0, len(group.Config))
+	for _, c := range group.Config {
+		if c.Ref == nil {
+			entries = append(entries, c.Entries)
+		}
+	}
+	return entries
+}
+
+func (cs *ConfigStore) indexConfigEntries(ctx context.Context, config *Config) error {
+	return cs.indexEntries(ctx, ConfigKind, config.Id, config.Version, config.Entries)
+}
+
+// indexGroupEntries indexes the entries of the group's own members and the
+// configs its references point at.
+func (cs *ConfigStore) indexGroupEntries(ctx context.Context, group *Group) error {
+	if err := cs.indexEntries(ctx, GroupKind, group.Id, group.Version, groupEntries(group)...); err != nil {
+		return err
+	}
+	return cs.indexGroupRefs(ctx, group)
+}
+
+func (cs *ConfigStore) unindexGroupEntries(ctx context.Context, group *Group) error {
+	if err := cs.unindexEntries(ctx, GroupKind, group.Id, group.Version, groupEntries(group)...); err != nil {
+		return err
+	}
+	return cs.unindexGroupRefs(ctx, group)
+}
+
+// unindexStored removes the entries of a stored config or group value from
+// the index.
+func (cs *ConfigStore) unindexStored(ctx context.Context, kind string, data []byte) error {
+	if kind == GroupKind {
+		group := &Group{}
+		if err := json.Unmarshal(data, group); err != nil {
+			return err
+		}
+		return cs.unindexGroupEntries(ctx, group)
+	}
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return err
+	}
+	return cs.unindexEntries(ctx, kind, config.Id, config.Version, config.Entries)
+}
+
+func matcher(match string, pattern string) (func(s string) bool, error) {
+	if pattern == "" {
+		return func(s string) bool { return true }, nil
+	}
+	switch match {
+	case "", MatchExact:
+		return func(s string) bool { return s == pattern }, nil
+	case MatchPrefix:
+		return func(s string) bool { return strings.HasPrefix(s, pattern) }, nil
+	case MatchRegex:
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+		return re.MatchString, nil
+	}
+	return nil, fmt.Errorf("%w: match must be %s, %s or %s", ErrInvalidQuery, MatchExact, MatchPrefix, MatchRegex)
+}
+
+// indexedPrefix returns the text every string pattern matches starts with
+// and whether it is the whole string, ok is false when the index cannot be
+// narrowed down for pattern.
+func indexedPrefix(match string, pattern string) (prefix string, whole bool, ok bool) {
+	switch match {
+	case "", MatchExact:
+		return pattern, true, true
+	case MatchPrefix:
+		return pattern, false, true
+	}
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", false, false
+	}
+	re = re.Simplify()
+	if re.Op != syntax.OpConcat || len(re.Sub) < 2 || re.Sub[0].Op != syntax.OpBeginText {
+		return "", false, false
+	}
+	lit := re.Sub[1]
+	if lit.Op != syntax.OpLiteral || lit.Flags&syntax.FoldCase != 0 {
+		return "", false, false
+	}
+	return string(lit.Rune), false, true
+}
+
+// SearchEntries finds the config and group entries matching q, including
+// the groups that reference a matching config. Only the keys of an entry
+// index are read, narrowed down to the searched key, or to the searched
+// value when the key does not allow it.
+func (cs *ConfigStore) SearchEntries(ctx context.Context, q EntryQuery) ([]*EntryHit, error) {
+	span := tracer.StartSpanFromContext(ctx, "SearchEntries")
+	defer span.Finish()
+	if q.Key == "" && q.Value == "" {
+		return nil, fmt.Errorf("%w: a key or a value is required", ErrInvalidQuery)
+	}
+	matchKey, err := matcher(q.Match, q.Key)
+	if err != nil {
+		return nil, err
+	}
+	matchValue, err := matcher(q.Match, q.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	var index, prefix string
+	byValue := false
+	if key, whole, ok := indexedPrefix(q.Match, q.Key); q.Key != "" && ok {
+		index, prefix = allEntryIndex, segment(key)
+		if whole {
+			prefix += "/"
+			if value, whole, ok := indexedPrefix(q.Match, q.Value); q.Value != "" && ok {
+				prefix += segment(value)
+				if whole {
+					prefix += "/"
+				}
+			}
+		}
+	} else if value, whole, ok := indexedPrefix(q.Match, q.Value); q.Value != "" && ok {
+		index, prefix, byValue = allValueIndex, segment(value), true
+		if whole {
+			prefix += "/"
+		}
+	} else {
+		return nil, fmt.Errorf("%w: a regex must start with ^ and literal text", ErrInvalidQuery)
+	}
+	keys, _, err := cs.kv.Keys(index+prefix, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := []*EntryHit{}
+	for _, key := range keys {
+		parts := strings.Split(strings.TrimPrefix(key, index), "/")
+		if len(parts) != 5 {
+			continue
+		}
+		for i := range parts {
+			if parts[i], err = url.PathUnescape(parts[i]); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			continue
+		}
+		if byValue {
+			parts[0], parts[1] = parts[1], parts[0]
+		}
+		hit := &EntryHit{Key: parts[0], Value: parts[1], Kind: parts[2], Id: parts[3], Version: parts[4]}
+		if matchKey(hit.Key) && matchValue(hit.Value) {
+			hits = append(hits, hit)
+		}
+	}
+	if hits, err = cs.referencingHits(ctx, hits); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Id != b.Id {
+			return a.Id < b.Id
+		}
+		if c := compareVersions(a.Version, b.Version); c != 0 {
+			return c < 0
+		}
+		return a.Key < b.Key
+	})
+	return hits, nil
+}
+
+// referencingHits adds a group hit for every group whose reference
+// resolves to a config version among the hits. Referenced members are not
+// in the entry index themselves because their entries change with the
+// config.
+func (cs *ConfigStore) referencingHits(ctx context.Context, hits []*EntryHit) ([]*EntryHit, error) {
+	seen := map[EntryHit]bool{}
+	for _, hit := range hits {
+		seen[*hit] = true
+	}
+	groups := map[string][]*Group{}
+	for _, hit := range hits {
+		if hit.Kind != ConfigKind {
+			continue
+		}
+		key := configKeyVersion(ctx, hit.Id, hit.Version)
+		referencing, ok := groups[key]
+		if !ok {
+			var err error
+			if referencing, err = cs.referencingGroups(ctx, hit.Id, hit.Version); err != nil {
+				return nil, err
+			}
+			groups[key] = referencing
+		}
+		for _, group := range referencing {
+			ref := EntryHit{Kind: GroupKind, Id: group.Id, Version: group.Version, Key: hit.Key, Value: hit.Value}
+			if !seen[ref] {
+				seen[ref] = true
+				hits = append(hits, &ref)
+			}
+		}
+	}
+	return hits, nil
+}
+
+// indexAllEntries rebuilds the entry, value and reference indexes from every
+// stored version.
+func (cs *ConfigStore) indexAllEntries(ctx context.Context) error {
+	span := tracer.StartSpanFromContext(ctx, "indexAllEntries")
+	defer span.Finish()
+	if _, err := cs.kv.DeleteTree(allEntryIndex, nil); err != nil {
+		return err
+	}
+	if _, err := cs.kv.DeleteTree(allValueIndex, nil); err != nil {
+		return err
+	}
+	if _, err := cs.kv.DeleteTree(allRefIndex, nil); err != nil {
+		return err
+	}
+	configs, _, err := cs.kv.List(all, nil)
+	if err != nil {
+		return err
+	}
+	for _, pair := range configs {
+		config := &Config{}
+		if err := json.Unmarshal(pair.Value, config); err != nil {
+			return err
+		}
+		if err := cs.indexConfigEntries(ctx, config); err != nil {
+			return err
+		}
+	}
+	groups, _, err := cs.kv.List(allG, nil)
+	if err != nil {
+		return err
+	}
+	for _, pair := range groups {
+		group := &Group{}
+		if err := json.Unmarshal(pair.Value, group); err != nil {
+			return err
+		}
+		if err := cs.indexGroupEntries(ctx, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
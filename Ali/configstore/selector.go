@@ -0,0 +1,149 @@
+package configstore
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type operator string
+
+const (
+	opEquals    operator = "="
+	opNotEquals operator = "!="
+	opIn        operator = "in"
+	opNotIn     operator = "notin"
+	opExists    operator = "exists"
+	opNotExists operator = "!"
+)
+
+type requirement struct {
+	key      string
+	operator operator
+	values   []string
+}
+
+// Selector is a Kubernetes style label selector, a comma separated list of
+// requirements that all have to match: key=value, key==value, key!=value,
+// key in (a,b), key notin (a,b), key and !key.
+type Selector []requirement
+
+var setRequirement = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+
+func ParseSelector(selector string) (Selector, error) {
+	sel := Selector{}
+	for _, part := range splitRequirements(selector) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		r, err := parseRequirement(part)
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// splitRequirements splits on commas that are not inside a value set.
+func splitRequirements(selector string) []string {
+	parts := []string{}
+	depth, start := 0, 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, selector[start:])
+}
+
+func parseRequirement(part string) (requirement, error) {
+	if m := setRequirement.FindStringSubmatch(part); m != nil {
+		values := []string{}
+		for _, v := range strings.Split(m[3], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return requirement{}, fmt.Errorf("empty value set in %q", part)
+		}
+		return requirement{key: m[1], operator: operator(m[2]), values: values}, validKey(m[1])
+	}
+	for _, op := range []string{"!=", "==", "="} {
+		if i := strings.Index(part, op); i >= 0 {
+			key := strings.TrimSpace(part[:i])
+			value := strings.TrimSpace(part[i+len(op):])
+			o := opEquals
+			if op == "!=" {
+				o = opNotEquals
+			}
+			return requirement{key: key, operator: o, values: []string{value}}, validKey(key)
+		}
+	}
+	if strings.HasPrefix(part, "!") {
+		key := strings.TrimSpace(part[1:])
+		return requirement{key: key, operator: opNotExists}, validKey(key)
+	}
+	return requirement{key: part, operator: opExists}, validKey(part)
+}
+
+func validKey(key string) error {
+	if key == "" || strings.ContainsAny(key, " \t!=(),") {
+		return fmt.Errorf("invalid key %q in selector", key)
+	}
+	return nil
+}
+
+// Matches reports whether the entries satisfy every requirement. Entries
+// that are not mentioned by the selector are ignored.
+func (s Selector) Matches(entries map[string]string) bool {
+	for _, r := range s {
+		value, ok := entries[r.key]
+		switch r.operator {
+		case opExists:
+			if !ok {
+				return false
+			}
+		case opNotExists:
+			if ok {
+				return false
+			}
+		case opEquals:
+			if !ok || value != r.values[0] {
+				return false
+			}
+		case opNotEquals:
+			if ok && value == r.values[0] {
+				return false
+			}
+		case opIn:
+			if !ok || !contains(r.values, value) {
+				return false
+			}
+		case opNotIn:
+			if ok && contains(r.values, value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
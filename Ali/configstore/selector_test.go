@@ -0,0 +1,95 @@
+package configstore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     Selector
+	}{
+		{"", Selector{}},
+		{"env=prod", Selector{{key: "env", operator: opEquals, values: []string{"prod"}}}},
+		{"env==prod", Selector{{key: "env", operator: opEquals, values: []string{"prod"}}}},
+		{" env != prod ", Selector{{key: "env", operator: opNotEquals, values: []string{"prod"}}}},
+		{"env in (prod, staging)", Selector{{key: "env", operator: opIn, values: []string{"prod", "staging"}}}},
+		{"env notin (dev)", Selector{{key: "env", operator: opNotIn, values: []string{"dev"}}}},
+		{"tier", Selector{{key: "tier", operator: opExists}}},
+		{"!tier", Selector{{key: "tier", operator: opNotExists}}},
+		{"env in (a,b),tier,!beta,region=eu", Selector{
+			{key: "env", operator: opIn, values: []string{"a", "b"}},
+			{key: "tier", operator: opExists},
+			{key: "beta", operator: opNotExists},
+			{key: "region", operator: opEquals, values: []string{"eu"}},
+		}},
+		{"env=,", Selector{{key: "env", operator: opEquals, values: []string{""}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			got, err := ParseSelector(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSelectorErrors(t *testing.T) {
+	for _, selector := range []string{
+		"=prod",
+		"env in ()",
+		"env in ( , )",
+		"my key=prod",
+		"!",
+		"a(b",
+	} {
+		t.Run(selector, func(t *testing.T) {
+			if _, err := ParseSelector(selector); err == nil {
+				t.Errorf("%q parsed without error", selector)
+			}
+		})
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	entries := map[string]string{"env": "prod", "tier": "web"}
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env=dev", false},
+		{"env!=dev", true},
+		{"env!=prod", false},
+		{"missing!=x", true},
+		{"env in (dev,prod)", true},
+		{"env in (dev)", false},
+		{"missing in (x)", false},
+		{"env notin (dev)", true},
+		{"env notin (prod)", false},
+		{"missing notin (x)", true},
+		{"tier", true},
+		{"missing", false},
+		{"!missing", true},
+		{"!tier", false},
+		{"env=prod,tier=web", true},
+		{"env=prod,tier=db", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			sel, err := ParseSelector(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sel.Matches(entries); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
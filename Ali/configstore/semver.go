@@ -0,0 +1,230 @@
+package configstore
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// semver is a semantic version. New versions need all of major, minor and
+// patch, ranges and versions stored before that was enforced may leave
+// minor and patch out ("1", "1.2"), in which case they are treated as zero.
+type semver struct {
+	major, minor, patch uint64
+	parts               int
+	pre                 []string
+}
+
+var semverPattern = regexp.MustCompile(`^(0|[1-9]\d*)(?:\.(0|[1-9]\d*))?(?:\.(0|[1-9]\d*))?` +
+	`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+	`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+// parseSemver parses a full MAJOR.MINOR.PATCH semantic version.
+func parseSemver(version string) (*semver, error) {
+	v, err := parseVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	if v.parts != 3 {
+		return nil, fmt.Errorf("%w %q, expected semantic version like 1.2.3", ErrInvalidVersion, version)
+	}
+	return v, nil
+}
+
+// parseVersion parses a version that may leave out minor and patch.
+func parseVersion(version string) (*semver, error) {
+	m := semverPattern.FindStringSubmatch(version)
+	if m == nil {
+		return nil, fmt.Errorf("%w %q, expected semantic version like 1.2.3", ErrInvalidVersion, version)
+	}
+	v := &semver{parts: 1}
+	v.major, _ = strconv.ParseUint(m[1], 10, 64)
+	if m[2] != "" {
+		v.minor, _ = strconv.ParseUint(m[2], 10, 64)
+		v.parts = 2
+	}
+	if m[3] != "" {
+		v.patch, _ = strconv.ParseUint(m[3], 10, 64)
+		v.parts = 3
+	}
+	if m[4] != "" {
+		v.pre = strings.Split(m[4], ".")
+	}
+	return v, nil
+}
+
+// nextPatchVersion returns version with its patch number incremented.
+func nextPatchVersion(version string) (string, error) {
+	v, err := parseVersion(version)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch+1), nil
+}
+
+// compare orders versions by semantic version precedence.
+func (v *semver) compare(o *semver) int {
+	if c := compareUint(v.major, o.major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.minor, o.minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.patch, o.patch); c != 0 {
+		return c
+	}
+	switch {
+	case len(v.pre) == 0 && len(o.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(o.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(o.pre); i++ {
+		x, xerr := strconv.ParseUint(v.pre[i], 10, 64)
+		y, yerr := strconv.ParseUint(o.pre[i], 10, 64)
+		switch {
+		case xerr == nil && yerr == nil:
+			if c := compareUint(x, y); c != 0 {
+				return c
+			}
+		case xerr == nil:
+			return -1
+		case yerr == nil:
+			return 1
+		default:
+			if c := strings.Compare(v.pre[i], o.pre[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareUint(uint64(len(v.pre)), uint64(len(o.pre)))
+}
+
+func compareUint(a uint64, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareVersions orders two version strings, versions that are not
+// valid semantic versions sort before valid ones and lexically among each other.
+func compareVersions(a string, b string) int {
+	av, aerr := parseVersion(a)
+	bv, berr := parseVersion(b)
+	switch {
+	case aerr == nil && berr == nil:
+		return av.compare(bv)
+	case aerr == nil:
+		return 1
+	case berr == nil:
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+type comparator struct {
+	op      string
+	version *semver
+}
+
+// versionRange is a set of comparators that all have to match, such as
+// "^1.2", "~1.2.3", ">=1.0 <2.0", "=1.4.0" or "*".
+type versionRange []comparator
+
+var rangeOperator = regexp.MustCompile(`^(\^|~|>=|<=|>|<|=)?\s*(.+)$`)
+
+func parseVersionRange(expr string) (versionRange, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, fmt.Errorf("%w range %q", ErrInvalidVersion, expr)
+	}
+	r := versionRange{}
+	for _, field := range strings.Fields(expr) {
+		if field == "*" || field == "x" {
+			continue
+		}
+		m := rangeOperator.FindStringSubmatch(field)
+		if m == nil {
+			return nil, fmt.Errorf("%w range %q", ErrInvalidVersion, expr)
+		}
+		v, err := parseVersion(m[2])
+		if err != nil {
+			return nil, err
+		}
+		op := m[1]
+		if op == "" {
+			op = "="
+		}
+		switch op {
+		case "^":
+			r = append(r, comparator{">=", v}, comparator{"<", caretUpper(v)})
+		case "~":
+			r = append(r, comparator{">=", v}, comparator{"<", tildeUpper(v)})
+		default:
+			r = append(r, comparator{op, v})
+		}
+	}
+	return r, nil
+}
+
+// caretUpper allows changes that do not modify the left-most non-zero part.
+func caretUpper(v *semver) *semver {
+	switch {
+	case v.major > 0 || v.parts == 1:
+		return &semver{major: v.major + 1, parts: 3}
+	case v.minor > 0 || v.parts == 2:
+		return &semver{minor: v.minor + 1, parts: 3}
+	}
+	return &semver{patch: v.patch + 1, parts: 3}
+}
+
+// tildeUpper allows patch changes, or minor changes if only a major is given.
+func tildeUpper(v *semver) *semver {
+	if v.parts == 1 {
+		return &semver{major: v.major + 1, parts: 3}
+	}
+	return &semver{major: v.major, minor: v.minor + 1, parts: 3}
+}
+
+// matches reports whether v is in the range. Pre-releases only match when
+// the range itself mentions a pre-release.
+func (r versionRange) matches(v *semver) bool {
+	if len(v.pre) > 0 && !r.allowsPrerelease() {
+		return false
+	}
+	for _, c := range r {
+		cmp := v.compare(c.version)
+		ok := false
+		switch c.op {
+		case "=":
+			ok = cmp == 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (r versionRange) allowsPrerelease() bool {
+	for _, c := range r {
+		if len(c.version.pre) > 0 {
+			return true
+		}
+	}
+	return false
+}
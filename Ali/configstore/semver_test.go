@@ -0,0 +1,159 @@
+package configstore
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"testing"
+)
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		version string
+		valid   bool
+	}{
+		{"1.2.3", true},
+		{"0.0.0", true},
+		{"1.2.3-rc.1", true},
+		{"1.2.3-alpha-2+build.5", true},
+		{"1", false},
+		{"1.2", false},
+		{"01.2.3", false},
+		{"1.2.3-", false},
+		{"1.2.3-01", false},
+		{"v1.2.3", false},
+		{"latest", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			_, err := parseSemver(tt.version)
+			if tt.valid && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidVersion) {
+				t.Errorf("got %v, want %v", err, ErrInvalidVersion)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0.0", "2.0.0", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"1.0.0-alpha", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-rc.1", "1.0.0-beta.11", 1},
+		{"1.0.0+build", "1.0.0", 0},
+		{"1.2", "1.2.0", 0},
+		{"abc", "1.0.0", -1},
+		{"abc", "abd", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			if got := compareVersions(tt.a, tt.b); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+			if got := compareVersions(tt.b, tt.a); got != -tt.want {
+				t.Errorf("reversed got %d, want %d", got, -tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionRangeMatches(t *testing.T) {
+	tests := []struct {
+		expr    string
+		version string
+		want    bool
+	}{
+		{"^1.2", "1.2.0", true},
+		{"^1.2", "1.9.9", true},
+		{"^1.2", "2.0.0", false},
+		{"^1.2", "1.1.9", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"^0.0.3", "0.0.4", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"~1", "1.9.0", true},
+		{">=1.0 <2.0", "1.5.0", true},
+		{">=1.0 <2.0", "2.0.0", false},
+		{"=1.4.0", "1.4.0", true},
+		{"1.4.0", "1.4.1", false},
+		{"*", "3.0.0", true},
+		{"^1.2", "1.3.0-rc.1", false},
+		{"^1.3.0-rc.1", "1.3.0-rc.2", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr+" "+tt.version, func(t *testing.T) {
+			r, err := parseVersionRange(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := parseSemver(tt.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := r.matches(v); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveConfigVersion(t *testing.T) {
+	ctx := context.Background()
+	cs := NewInMemory()
+	config, err := cs.Post(ctx, &Config{Version: "1.0.0", Entries: map[string]string{"a": "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct{ version, state string }{
+		{"1.2.0", PublishedState},
+		{"1.3.0-rc.1", PublishedState},
+		{"2.0.0", PublishedState},
+		{"2.1.0", DraftState},
+	} {
+		_, err := cs.AddConfigVersion(ctx, &Config{Id: config.Id, Version: v.version, State: v.state, Entries: map[string]string{"a": v.version}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{"1.2.0", "1.2.0"},
+		{"2.1.0", "2.1.0"},
+		{LatestVersion, "2.0.0"},
+		{StableVersion, "2.0.0"},
+		{"^1", "1.2.0"},
+		{"^1.3.0-rc.0", "1.3.0-rc.1"},
+		{"<2", "1.2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got, err := cs.resolveConfigVersion(ctx, config.Id, tt.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+	if _, err := cs.resolveConfigVersion(ctx, config.Id, "^3"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want %v", err, ErrNotFound)
+	}
+	if _, err := cs.AddConfigVersion(ctx, &Config{Id: config.Id, Version: "3", Entries: map[string]string{}}); !errors.Is(err, ErrInvalidVersion) {
+		t.Errorf("partial version: got %v, want %v", err, ErrInvalidVersion)
+	}
+}
@@ -0,0 +1,129 @@
+package configstore
+
+import (
+	tracer "Ali/tracer"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/net/context"
+)
+
+// Version states. Drafts can be changed in place, published versions are
+// read-only and every change has to create a new version.
+const (
+	DraftState     = "draft"
+	PublishedState = "published"
+)
+
+func validState(state string) (string, error) {
+	switch state {
+	case "":
+		return PublishedState, nil
+	case DraftState, PublishedState:
+		return state, nil
+	}
+	return "", fmt.Errorf("%w %q, expected %s or %s", ErrInvalidState, state, DraftState, PublishedState)
+}
+
+// PutConfig replaces the entries of a draft config version.
+func (cs *ConfigStore) PutConfig(ctx context.Context, config *Config) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "PutConfig")
+	defer span.Finish()
+	if config.Index == 0 {
+		return nil, ErrPreconditionFailed
+	}
+	current, err := cs.GetConf(ctx, config.Id, config.Version)
+	if err != nil {
+		return nil, err
+	}
+	if current.State != DraftState {
+		return nil, ErrImmutable
+	}
+	config.State = DraftState
+	config.Meta = nil
+	if current.Meta != nil {
+		config.Meta = &Metadata{Parent: current.Meta.Parent, Tags: current.Meta.Tags}
+	}
+	config.Meta, err = cs.configMetadata(ctx, config, false)
+	if err != nil {
+		return nil, err
+	}
+	err = cs.replace(configKeyVersion(ctx, config.Id, config.Version), config, config.Index)
+	if err != nil {
+		return nil, err
+	}
+	err = cs.unindexEntries(ctx, ConfigKind, current.Id, current.Version, current.Entries)
+	if err != nil {
+		return nil, err
+	}
+	err = cs.indexConfigEntries(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return cs.GetConf(ctx, config.Id, config.Version)
+}
+
+// PublishConfig makes a draft config version read-only.
+func (cs *ConfigStore) PublishConfig(ctx context.Context, id string, version string, index uint64) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "PublishConfig")
+	defer span.Finish()
+	config, err := cs.GetConf(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	if config.Index != index {
+		return nil, ErrPreconditionFailed
+	}
+	if config.State == DraftState {
+		config.State = PublishedState
+		err = cs.replace(configKeyVersion(ctx, id, config.Version), config, index)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cs.GetConf(ctx, id, config.Version)
+}
+
+// PublishGroup makes a draft group version read-only. Its references are
+// kept as they were given.
+func (cs *ConfigStore) PublishGroup(ctx context.Context, id string, version string, index uint64) (*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "PublishGroup")
+	defer span.Finish()
+	group, err := cs.GetGroup(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	if group.Index != index {
+		return nil, ErrPreconditionFailed
+	}
+	if group.State == DraftState {
+		group.State = PublishedState
+		unresolveRefs(group)
+		if group.Meta != nil {
+			if group.Meta.Hash, err = contentHash(group.Config); err != nil {
+				return nil, err
+			}
+		}
+		err = cs.replace(configKeyGroupVersion(ctx, id, group.Version), group, index)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cs.GetGroup(ctx, id, group.Version)
+}
+
+// replace overwrites key with v if it is still at the given modify index.
+func (cs *ConfigStore) replace(key string, v interface{}, index uint64) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ok, _, err := cs.kv.CAS(&api.KVPair{Key: key, Value: data, ModifyIndex: index}, nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrPreconditionFailed
+	}
+	return nil
+}
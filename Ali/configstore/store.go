@@ -0,0 +1,51 @@
+package configstore
+
+import (
+	"golang.org/x/net/context"
+	"time"
+)
+
+// Store is the storage backend used by the config service.
+// ConfigStore is the Consul backed implementation.
+type Store interface {
+	Post(ctx context.Context, config *Config) (*Config, error)
+	ReserveId(ctx context.Context, reqId string, pending *IdempotentResponse) (*IdempotentResponse, error)
+	SaveId(ctx context.Context, reqId string, resp *IdempotentResponse) error
+	ReleaseId(ctx context.Context, reqId string) error
+	SweepIds(ctx context.Context, now time.Time) (int, error)
+	GetAll(ctx context.Context, opts ListOptions) ([]*Config, string, error)
+	GetAllGroups(ctx context.Context, opts ListOptions) ([]*Group, string, error)
+	AddConfigVersion(ctx context.Context, config *Config) (*Config, error)
+	GetConf(ctx context.Context, id string, version string) (*Config, error)
+	Delete(ctx context.Context, id string, version string, index uint64) (map[string]string, error)
+	GetConfVersions(ctx context.Context, id string) ([]*Config, error)
+	Group(ctx context.Context, group *Group) (*Group, error)
+	AddConfigGroupVersion(ctx context.Context, group *Group) (*Group, error)
+	DeleteGroup(ctx context.Context, id string, version string, index uint64) (map[string]string, error)
+	GetGroup(ctx context.Context, id string, version string) (*Group, error)
+	GetConfGroupVersions(ctx context.Context, id string) ([]*Group, error)
+	Put(ctx context.Context, group *Group) (*Group, error)
+	FilterGroup(ctx context.Context, id string, version string, labels map[string]string) ([]*ConfigG, error)
+	SelectGroup(ctx context.Context, id string, version string, sel Selector) ([]*ConfigG, error)
+	DiffConfig(ctx context.Context, id string, from string, to string) (*ConfigDiff, error)
+	DiffGroup(ctx context.Context, id string, from string, to string) (*GroupDiff, error)
+	RestoreConfig(ctx context.Context, id string, version string, as string) (*Config, error)
+	RestoreGroup(ctx context.Context, id string, version string, as string) (*Group, error)
+	PatchConfig(ctx context.Context, id string, base string, version string, mediatype string, patch []byte) (*Config, error)
+	PutConfig(ctx context.Context, config *Config) (*Config, error)
+	PublishConfig(ctx context.Context, id string, version string, index uint64) (*Config, error)
+	PublishGroup(ctx context.Context, id string, version string, index uint64) (*Group, error)
+	Prune(ctx context.Context, policy RetentionPolicy, now time.Time, dryRun bool) (*PruneReport, error)
+	ListTrash(ctx context.Context) ([]*TrashItem, error)
+	RestoreTrashedConfig(ctx context.Context, id string, version string) (*Config, error)
+	RestoreTrashedGroup(ctx context.Context, id string, version string) (*Group, error)
+	PurgeTrash(ctx context.Context, before time.Time) (int, error)
+	DeleteConfigTree(ctx context.Context, id string) (int, error)
+	DeleteGroupTree(ctx context.Context, id string) (int, error)
+	SchemaVersion(ctx context.Context) (int, error)
+	Migrate(ctx context.Context) ([]string, error)
+	SearchEntries(ctx context.Context, q EntryQuery) ([]*EntryHit, error)
+	Close() error
+}
+
+var _ Store = (*ConfigStore)(nil)
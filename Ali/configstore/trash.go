@@ -0,0 +1,193 @@
+package configstore
+
+import (
+	tracer "Ali/tracer"
+	"encoding/json"
+	"errors"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/net/context"
+	"sort"
+	"time"
+)
+
+// Kinds of stored versions.
+const (
+	ConfigKind = "config"
+	GroupKind  = "group"
+)
+
+// TrashItem is a deleted config or group version kept under the trash
+// prefix until it is restored or purged.
+type TrashItem struct {
+	Kind      string          `json:"kind"`
+	Id        string          `json:"id"`
+	Version   string          `json:"version"`
+	DeletedAt time.Time       `json:"deletedAt"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// moveToTrash copies the version stored under key to the trash and removes
+// it if it is still at the given modify index. Every call writes a new
+// trash key, so deleting a version that was created again keeps the copy
+// trashed before.
+func (cs *ConfigStore) moveToTrash(ctx context.Context, kind string, id string, version string, key string, index uint64) error {
+	span := tracer.StartSpanFromContext(ctx, "moveToTrash")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+	kv := cs.kv
+	pair, _, err := kv.Get(key, nil)
+	if err != nil {
+		return err
+	}
+	if pair == nil {
+		return ErrNotFound
+	}
+	if pair.ModifyIndex != index {
+		return ErrPreconditionFailed
+	}
+
+	item := &TrashItem{Kind: kind, Id: id, Version: version, DeletedAt: time.Now().UTC(), Value: pair.Value}
+	var tkey string
+	for {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		tkey = trashKey(ctx, kind, id, version, item.DeletedAt)
+		_, err = cs.create(tkey, data)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrVersionExists) {
+			return err
+		}
+		// deleted twice within the same nanosecond
+		item.DeletedAt = item.DeletedAt.Add(time.Nanosecond)
+	}
+	ok, _, err := kv.DeleteCAS(&api.KVPair{Key: key, ModifyIndex: index}, nil)
+	if err == nil && !ok {
+		err = ErrPreconditionFailed
+	}
+	if err != nil {
+		// the version is still there, drop the copy this call wrote
+		kv.Delete(tkey, nil)
+		return err
+	}
+	return cs.unindexStored(ctx, kind, pair.Value)
+}
+
+func (cs *ConfigStore) ListTrash(ctx context.Context) ([]*TrashItem, error) {
+	span := tracer.StartSpanFromContext(ctx, "ListTrash")
+	defer span.Finish()
+	data, _, err := cs.kv.List(allTrash, nil)
+	if err != nil {
+		return nil, err
+	}
+	items := []*TrashItem{}
+	for _, pair := range data {
+		item := &TrashItem{}
+		if err := json.Unmarshal(pair.Value, item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// restoreFromTrash puts the most recently trashed copy of a version back
+// under key. It fails with ErrVersionExists when the version was created
+// again in the meantime.
+func (cs *ConfigStore) restoreFromTrash(ctx context.Context, kind string, id string, version string, key string) ([]byte, uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "restoreFromTrash")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+	kv := cs.kv
+	data, _, err := kv.List(trashVersionKey(ctx, kind, id, version), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) == 0 {
+		return nil, 0, ErrNotFound
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Key < data[j].Key })
+	pair := data[len(data)-1]
+	item := &TrashItem{}
+	if err := json.Unmarshal(pair.Value, item); err != nil {
+		return nil, 0, err
+	}
+	index, err := cs.create(key, item.Value)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, _, err := kv.DeleteCAS(pair, nil); err != nil {
+		return nil, 0, err
+	}
+	return item.Value, index, nil
+}
+
+func (cs *ConfigStore) RestoreTrashedConfig(ctx context.Context, id string, version string) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "RestoreTrashedConfig")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+	data, index, err := cs.restoreFromTrash(ctx, ConfigKind, id, version, configKeyVersion(ctx, id, version))
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	config.Index = index
+	if err := cs.indexConfigEntries(ctx, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (cs *ConfigStore) RestoreTrashedGroup(ctx context.Context, id string, version string) (*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "RestoreTrashedGroup")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+	data, index, err := cs.restoreFromTrash(ctx, GroupKind, id, version, configKeyGroupVersion(ctx, id, version))
+	if err != nil {
+		return nil, err
+	}
+	group := &Group{}
+	if err := json.Unmarshal(data, group); err != nil {
+		return nil, err
+	}
+	group.Index = index
+	if err := cs.indexGroupLabels(ctx, group); err != nil {
+		return nil, err
+	}
+	if err := cs.indexGroupEntries(ctx, group); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// PurgeTrash removes the items deleted before the given time.
+func (cs *ConfigStore) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	span := tracer.StartSpanFromContext(ctx, "PurgeTrash")
+	defer span.Finish()
+	kv := cs.kv
+	data, _, err := kv.List(allTrash, nil)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, pair := range data {
+		item := &TrashItem{}
+		if err := json.Unmarshal(pair.Value, item); err == nil && !item.DeletedAt.Before(before) {
+			continue
+		}
+		ok, _, err := kv.DeleteCAS(pair, nil)
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed++
+		}
+	}
+	return removed, nil
+}
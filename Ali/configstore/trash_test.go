@@ -0,0 +1,159 @@
+package configstore
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/net/context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func deleteConfig(t *testing.T, cs *ConfigStore, id string, version string) {
+	t.Helper()
+	ctx := context.Background()
+	config, err := cs.GetConf(ctx, id, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.Delete(ctx, id, version, config.Index); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func trashedValues(t *testing.T, cs *ConfigStore) []string {
+	t.Helper()
+	items, err := cs.ListTrash(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := []string{}
+	for _, item := range items {
+		config := &Config{}
+		if err := json.Unmarshal(item.Value, config); err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, config.Entries["v"])
+	}
+	return values
+}
+
+func TestTrashRestore(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		deletes  []string
+		restored string
+		left     []string
+	}{
+		{"deleted once", []string{"1"}, "1", []string{}},
+		{"recreated and deleted again", []string{"1", "2"}, "2", []string{"1"}},
+		{"deleted three times", []string{"1", "2", "3"}, "3", []string{"1", "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := NewInMemory()
+			config, err := cs.Post(ctx, &Config{Version: "1.0.0", Entries: map[string]string{"v": tt.deletes[0]}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, v := range tt.deletes {
+				if i > 0 {
+					if _, err := cs.AddConfigVersion(ctx, &Config{Id: config.Id, Version: "1.0.0", Entries: map[string]string{"v": v}}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				deleteConfig(t, cs, config.Id, "1.0.0")
+			}
+			if got := trashedValues(t, cs); len(got) != len(tt.deletes) {
+				t.Fatalf("trash holds %v, want every deleted copy %v", got, tt.deletes)
+			}
+
+			restored, err := cs.RestoreTrashedConfig(ctx, config.Id, "1.0.0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restored.Entries["v"] != tt.restored {
+				t.Errorf("restored v=%s, want v=%s", restored.Entries["v"], tt.restored)
+			}
+			if _, err := cs.RestoreTrashedConfig(ctx, config.Id, "1.0.0"); len(tt.left) > 0 && !errors.Is(err, ErrVersionExists) {
+				t.Errorf("restore over a stored version: got %v, want %v", err, ErrVersionExists)
+			}
+			if got := trashedValues(t, cs); !reflect.DeepEqual(got, tt.left) {
+				t.Errorf("trash holds %v, want %v", got, tt.left)
+			}
+			hits, err := cs.SearchEntries(ctx, EntryQuery{Key: "v", Value: tt.restored})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(hits) != 1 {
+				t.Errorf("restored entries found %d times, want once", len(hits))
+			}
+		})
+	}
+}
+
+// casFailingKV loses every check-and-set delete, as if the pair was written
+// concurrently.
+type casFailingKV struct {
+	kvBackend
+}
+
+func (kv casFailingKV) DeleteCAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	return false, nil, nil
+}
+
+func TestMoveToTrashRollback(t *testing.T) {
+	ctx := context.Background()
+	cs := NewInMemory()
+	config, err := cs.Post(ctx, &Config{Version: "1.0.0", Entries: map[string]string{"v": "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleteConfig(t, cs, config.Id, "1.0.0")
+	recreated, err := cs.AddConfigVersion(ctx, &Config{Id: config.Id, Version: "1.0.0", Entries: map[string]string{"v": "2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failing := &ConfigStore{kv: casFailingKV{cs.kv}}
+	if _, err := failing.Delete(ctx, config.Id, "1.0.0", recreated.Index); !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("got %v, want %v", err, ErrPreconditionFailed)
+	}
+	if got, want := trashedValues(t, cs), []string{"1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("trash holds %v, want %v", got, want)
+	}
+	if _, err := cs.GetConf(ctx, config.Id, "1.0.0"); err != nil {
+		t.Errorf("version is gone after a failed delete: %v", err)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	ctx := context.Background()
+	cs := NewInMemory()
+	for _, v := range []string{"1", "2"} {
+		config, err := cs.Post(ctx, &Config{Version: "1.0.0", Entries: map[string]string{"v": v}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		deleteConfig(t, cs, config.Id, "1.0.0")
+	}
+	tests := []struct {
+		before  time.Time
+		removed int
+		left    int
+	}{
+		{time.Now().Add(-time.Hour), 0, 2},
+		{time.Now().Add(time.Hour), 2, 0},
+	}
+	for _, tt := range tests {
+		removed, err := cs.PurgeTrash(ctx, tt.before)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed != tt.removed || len(trashedValues(t, cs)) != tt.left {
+			t.Errorf("purge before %v removed %d leaving %d, want %d leaving %d", tt.before, removed, len(trashedValues(t, cs)), tt.removed, tt.left)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package configstore
+
+import (
+	tracer "Ali/tracer"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"net/url"
+	"strings"
+)
+
+// Version aliases resolved by the store to a concrete version.
+const (
+	LatestVersion = "latest"
+	StableVersion = "stable"
+)
+
+func isVersionAlias(version string) bool {
+	return version == LatestVersion || version == StableVersion
+}
+
+func validVersion(version string) error {
+	if isVersionAlias(version) {
+		return fmt.Errorf("%w %q, it is a reserved alias", ErrInvalidVersion, version)
+	}
+	_, err := parseSemver(version)
+	return err
+}
+
+// resolveVersion returns version itself when it is stored under prefix as
+// is, otherwise it is treated as an alias or a range and resolved to the
+// highest matching published version.
+func (cs *ConfigStore) resolveVersion(ctx context.Context, prefix string, version string) (string, error) {
+	span := tracer.StartSpanFromContext(ctx, "resolveVersion")
+	defer span.Finish()
+	if _, err := parseSemver(version); err == nil {
+		return version, nil
+	}
+
+	var match func(v *semver) bool
+	switch version {
+	case LatestVersion:
+		match = func(v *semver) bool { return true }
+	case StableVersion:
+		match = func(v *semver) bool { return len(v.pre) == 0 }
+	default:
+		r, err := parseVersionRange(version)
+		if err != nil {
+			// not a range either, look the key up literally
+			return version, nil
+		}
+		match = r.matches
+	}
+	return cs.highestVersion(prefix, false, match)
+}
+
+// highestVersion returns the highest version stored under prefix accepted
+// by match. Drafts are skipped unless drafts is set.
+func (cs *ConfigStore) highestVersion(prefix string, drafts bool, match func(v *semver) bool) (string, error) {
+	pairs, _, err := cs.kv.List(prefix, nil)
+	if err != nil {
+		return "", err
+	}
+	var best *semver
+	bestVersion := ""
+	for _, pair := range pairs {
+		candidate, err := url.PathUnescape(strings.TrimPrefix(pair.Key, prefix))
+		if err != nil {
+			continue
+		}
+		v, err := parseVersion(candidate)
+		if err != nil || !match(v) {
+			continue
+		}
+		if !drafts {
+			var state struct {
+				State string `json:"state"`
+			}
+			if json.Unmarshal(pair.Value, &state) != nil || state.State == DraftState {
+				continue
+			}
+		}
+		if best == nil || v.compare(best) > 0 {
+			best, bestVersion = v, candidate
+		}
+	}
+	if best == nil {
+		return "", ErrNotFound
+	}
+	return bestVersion, nil
+}
+
+func (cs *ConfigStore) resolveConfigVersion(ctx context.Context, id string, version string) (string, error) {
+	return cs.resolveVersion(ctx, configKey(ctx, id), version)
+}
+
+func (cs *ConfigStore) resolveGroupVersion(ctx context.Context, id string, version string) (string, error) {
+	return cs.resolveVersion(ctx, configKeyGroup(ctx, id), version)
+}
+
+// lastConfigVersion returns the highest config version including drafts.
+func (cs *ConfigStore) lastConfigVersion(ctx context.Context, id string) (string, error) {
+	return cs.highestVersion(configKey(ctx, id), true, func(v *semver) bool { return true })
+}
+
+// lastGroupVersion returns the highest group version including drafts.
+func (cs *ConfigStore) lastGroupVersion(ctx context.Context, id string) (string, error) {
+	return cs.highestVersion(configKeyGroup(ctx, id), true, func(v *semver) bool { return true })
+}
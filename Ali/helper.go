@@ -3,11 +3,21 @@ package main
 import (
 	cs "Ali/configstore"
 	tracer "Ali/tracer"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"golang.org/x/net/context"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func decodeBody(ctx context.Context, r io.Reader) (*cs.Config, error) {
@@ -20,6 +30,8 @@ func decodeBody(ctx context.Context, r io.Reader) (*cs.Config, error) {
 	if err := dec.Decode(&rt); err != nil {
 		return nil, err
 	}
+	rt.Meta = nil
+	rt.DerivedFrom = ""
 	return &rt, nil
 }
 
@@ -34,9 +46,30 @@ func decodeBodyGroups(ctx context.Context, r io.Reader) (*cs.Group, error) {
 	if err := dec.Decode(&rt); err != nil {
 		return nil, err
 	}
+	rt.Meta = nil
+	rt.DerivedFrom = ""
 	return &rt, nil
 }
 
+func decodeSelector(ctx context.Context, selector string) (cs.Selector, error) {
+	span := tracer.StartSpanFromContext(ctx, "decodeSelector")
+	defer span.Finish()
+	return cs.ParseSelector(selector)
+}
+
+// withChange records the author, description and tags of a write, taken
+// from the X-User, X-Change-Description and X-Version-Tags headers. The
+// author is client-supplied and not verified.
+func withChange(ctx context.Context, req *http.Request) context.Context {
+	var tags []string
+	for _, tag := range strings.Split(req.Header.Get("X-Version-Tags"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return cs.WithChange(ctx, req.Header.Get("X-User"), req.Header.Get("X-Change-Description"), tags)
+}
+
 func renderJSON(ctx context.Context, w http.ResponseWriter, v interface{}) {
 	span := tracer.StartSpanFromContext(ctx, "decodeBody")
 	defer span.Finish()
@@ -50,6 +83,245 @@ func renderJSON(ctx context.Context, w http.ResponseWriter, v interface{}) {
 	w.Write(js)
 }
 
+// renderFilterResult writes the page of filter matches selected by the
+// limit and after query parameters, paged like the listings.
+func renderFilterResult(ctx context.Context, w http.ResponseWriter, req *http.Request, configs []*cs.ConfigG) {
+	opts, err := listOptions(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, next, err := cs.PageMembers(configs, opts)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+
+	setNextPage(w, req, next)
+	renderJSON(ctx, w, &filterResult{
+		Count:   len(page),
+		Total:   len(configs),
+		Next:    next,
+		Configs: page,
+	})
+}
+
+func queryInt(req *http.Request, name string, def int) (int, error) {
+	value := req.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return n, nil
+}
+
+// listOptions reads the limit, after and sort query parameters of a
+// listing.
+func listOptions(req *http.Request) (cs.ListOptions, error) {
+	limit, err := queryInt(req, "limit", 0)
+	if err != nil {
+		return cs.ListOptions{}, err
+	}
+	query := req.URL.Query()
+	return cs.ListOptions{Limit: limit, After: query.Get("after"), Sort: query.Get("sort")}, nil
+}
+
+// setNextPage points the client to the next page of a listing with the
+// X-Next-Cursor and Link headers.
+func setNextPage(w http.ResponseWriter, req *http.Request, next string) {
+	if next == "" {
+		return
+	}
+	query := req.URL.Query()
+	query.Set("after", next)
+	link := url.URL{Path: req.URL.Path, RawQuery: query.Encode()}
+	w.Header().Set("X-Next-Cursor", next)
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", link.String()))
+}
+
+// diffVersions reads the from and to query parameters of a diff request.
+func diffVersions(w http.ResponseWriter, req *http.Request) (string, string, bool) {
+	from := req.URL.Query().Get("from")
+	to := req.URL.Query().Get("to")
+	if from == "" || to == "" {
+		http.Error(w, "from and to versions are required", http.StatusBadRequest)
+		return "", "", false
+	}
+	return from, to, true
+}
+
+// confirmed checks that a destructive request repeats the id in the
+// confirm query parameter.
+func confirmed(w http.ResponseWriter, req *http.Request, id string) bool {
+	if req.URL.Query().Get("confirm") != id {
+		http.Error(w, "confirm must be set to the id being deleted", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func renderJSONPatch(ctx context.Context, w http.ResponseWriter, ops []cs.PatchOperation) {
+	span := tracer.StartSpanFromContext(ctx, "renderJSONPatch")
+	defer span.Finish()
+	js, err := json.Marshal(ops)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json-patch+json")
+	w.Write(js)
+}
+
+func isPatchType(mediatype string) bool {
+	return mediatype == cs.JSONPatchType || mediatype == cs.MergePatchType
+}
+
 func createId() string {
 	return uuid.New().String()
 }
+
+func setETag(w http.ResponseWriter, index uint64) {
+	w.Header().Set("ETag", fmt.Sprintf("\"%d\"", index))
+}
+
+// ifMatch reads the index the client expects from the If-Match header.
+// It writes the error response itself and returns false when the header
+// is missing or malformed.
+func ifMatch(w http.ResponseWriter, req *http.Request) (uint64, bool) {
+	etag := req.Header.Get("If-Match")
+	if etag == "" {
+		http.Error(w, "If-Match header is required", http.StatusPreconditionRequired)
+		return 0, false
+	}
+	etag = strings.Trim(strings.TrimPrefix(etag, "W/"), "\"")
+	index, err := strconv.ParseUint(etag, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid If-Match header", http.StatusBadRequest)
+		return 0, false
+	}
+	return index, true
+}
+
+func storeError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), errorStatus(err))
+}
+
+// errorStatus maps a store error to the status of its response.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, cs.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, cs.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, cs.ErrVersionExists), errors.Is(err, cs.ErrImmutable), errors.Is(err, cs.ErrReferenced):
+		return http.StatusConflict
+	case errors.Is(err, cs.ErrInvalidPatch), errors.Is(err, cs.ErrInvalidReference):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadRequest
+}
+
+// renderDeleteResult writes how many versions of id were moved to the
+// trash. A delete that failed part-way keeps the status of its error and
+// reports the versions already trashed next to it.
+func renderDeleteResult(ctx context.Context, w http.ResponseWriter, id string, removed int, err error) {
+	if err != nil && removed == 0 {
+		storeError(w, err)
+		return
+	}
+	result := &deleteResult{Deleted: id, Versions: removed}
+	if err != nil {
+		result.Error = err.Error()
+		js, _ := json.Marshal(result)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(errorStatus(err))
+		w.Write(js)
+		return
+	}
+	renderJSON(ctx, w, result)
+}
+
+// responseRecorder buffers a handler response so it can be stored
+// for idempotent replays before it is sent to the client.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: http.Header{}}
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) response() *cs.IdempotentResponse {
+	r.WriteHeader(http.StatusOK)
+	return &cs.IdempotentResponse{
+		Status: r.status,
+		Header: r.header,
+		Body:   r.body.Bytes(),
+	}
+}
+
+func writeResponse(w http.ResponseWriter, resp *cs.IdempotentResponse) {
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
+
+// requestFingerprint hashes the method, path and decoded payload so a
+// reused idempotency key can be told apart from a genuine retry.
+func requestFingerprint(req *http.Request, payload interface{}) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(req.Method + " " + req.URL.Path + "\n"))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func intEnv(name string, def int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return n, nil
+}
+
+func durationEnv(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	cs "Ali/configstore"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestRenderFilterResult(t *testing.T) {
+	configs := []*cs.ConfigG{}
+	for i := 0; i < 5; i++ {
+		configs = append(configs, &cs.ConfigG{Entries: map[string]string{"n": strconv.Itoa(i)}})
+	}
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		pages  int
+	}{
+		{"default limit", "", http.StatusOK, 1},
+		{"zero limit", "limit=0", http.StatusOK, 1},
+		{"pages of two", "limit=2", http.StatusOK, 3},
+		{"limit of all", "limit=5", http.StatusOK, 1},
+		{"overflowing limit", "limit=9223372036854775807", http.StatusBadRequest, 0},
+		{"limit above maximum", "limit=1001", http.StatusBadRequest, 0},
+		{"negative limit", "limit=-1", http.StatusBadRequest, 0},
+		{"garbage cursor", "after=nope", http.StatusBadRequest, 0},
+		{"sorted", "sort=id", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			pages := 0
+			for {
+				req := httptest.NewRequest("GET", "/group/g/1.0.0/?"+query.Encode(), nil)
+				w := httptest.NewRecorder()
+				renderFilterResult(context.Background(), w, req, configs)
+				if w.Code != tt.status {
+					t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+				}
+				if w.Code != http.StatusOK {
+					return
+				}
+				result := &filterResult{}
+				if err := json.Unmarshal(w.Body.Bytes(), result); err != nil {
+					t.Fatal(err)
+				}
+				if result.Total != len(configs) || result.Count != len(result.Configs) {
+					t.Errorf("count %d of %d for %d configs", result.Count, result.Total, len(result.Configs))
+				}
+				for _, c := range result.Configs {
+					got = append(got, c.Entries["n"])
+				}
+				pages++
+				if result.Next == "" {
+					break
+				}
+				if next := w.Header().Get("X-Next-Cursor"); next != result.Next {
+					t.Errorf("X-Next-Cursor %q, body next %q", next, result.Next)
+				}
+				if pages > len(configs) {
+					t.Fatal("next page never ends")
+				}
+				query.Set("after", result.Next)
+			}
+			if len(got) != len(configs) || pages != tt.pages {
+				t.Errorf("read %v in %d pages, want all %d in %d", got, pages, len(configs), tt.pages)
+			}
+			for i, n := range got {
+				if n != strconv.Itoa(i) {
+					t.Errorf("got %v, want members in group order", got)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestRenderDeleteResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		removed int
+		err     error
+		status  int
+		body    *deleteResult
+	}{
+		{"deleted", 3, nil, http.StatusOK, &deleteResult{Deleted: "c", Versions: 3}},
+		{"nothing deleted", 0, cs.ErrNotFound, http.StatusNotFound, nil},
+		{"failed before the first", 0, cs.ErrPreconditionFailed, http.StatusPreconditionFailed, nil},
+		{"failed part-way", 2, cs.ErrPreconditionFailed, http.StatusPreconditionFailed,
+			&deleteResult{Deleted: "c", Versions: 2, Error: cs.ErrPreconditionFailed.Error()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			renderDeleteResult(context.Background(), w, "c", tt.removed, tt.err)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			if tt.body == nil {
+				return
+			}
+			got := &deleteResult{}
+			if err := json.Unmarshal(w.Body.Bytes(), got); err != nil {
+				t.Fatalf("%s: %v", w.Body, err)
+			}
+			if *got != *tt.body {
+				t.Errorf("got %+v, want %+v", got, tt.body)
+			}
+		})
+	}
+}
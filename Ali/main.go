@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
@@ -12,32 +13,40 @@ import (
 )
 
 func main() {
-	quit := make(chan os.Signal)
-	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	migrate := flag.Bool("migrate", false, "apply pending store migrations and exit")
+	flag.Parse()
+	if *migrate {
+		store, err := newStore()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := migrateStore(store); err != nil {
+			log.Fatal(err)
+		}
+		if err := store.Close(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	router := mux.NewRouter()
-	router.StrictSlash(true)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
 	server, err := NewCOnfigServer()
 	if err != nil {
 		log.Fatal(err)
 	}
+	router := server.routes()
 
-	router.HandleFunc("/config/", countCreateConfig(server.createPostHandler)).Methods("POST")
-	router.HandleFunc("/configs/", countGetAll(server.getAllHandler)).Methods("GET")
-	router.HandleFunc("/configs/{id}", countConfigVersions(server.getConfigVersionsHandler)).Methods("GET")
-	router.HandleFunc("/configs/{id}/{version}", countGetConfig(server.getConfigHandler)).Methods("GET")
-	router.HandleFunc("/config/{id}", countAddConfigVersion(server.addConfigVersion)).Methods("POST")
-	router.HandleFunc("/config/{id}/{version}", countdelConfigVersion(server.delConfigHandler)).Methods("DELETE")
-	router.HandleFunc("/group/", counteCreateGroup(server.createGroupHandler)).Methods("POST")
-	router.HandleFunc("/group/", countegetAllGroup(server.getAllGroupHandler)).Methods("GET")
-	router.HandleFunc("/group/{id}/", counteAddGroupVersion(server.addConfigGroupVersion)).Methods("POST")
-	router.HandleFunc("/group/{id}/", counteGetConfigGroupVersions(server.getConfigGroupVersions)).Methods("GET")
-	router.HandleFunc("/group/{id}/{version}/", counteGetGroupVersion(server.getGroupVersionsHandler)).Methods("GET")
-	router.HandleFunc("/group/{id}/{version}/{labels}/", filter(server.filter)).Methods("GET")
-	router.HandleFunc("/group/{id}/{version}/", counteDelgroupHits(server.delGroupHandler)).Methods("DELETE")
-	router.HandleFunc("/group/{id}/{version}", counteAddConfigToGroup(server.addConfig)).Methods("PUT")
-	router.Path("/metrics").Handler(metricsHandler())
+	go server.sweepIdempotencyKeys(idempotencySweepInterval)
+	go server.purgeTrash(trashPurgeInterval)
+	retentionInterval, err := durationEnv("RETENTION_INTERVAL", defaultRetentionInterval)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if retentionInterval > 0 {
+		go server.pruneVersions(retentionInterval)
+	}
 
 	srv := &http.Server{Addr: "0.0.0.0:8000", Handler: router}
 	go func() {
@@ -60,5 +69,47 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal(err)
 	}
+	if err := server.store.Close(); err != nil {
+		log.Fatal(err)
+	}
 	log.Println("server stopped")
 }
+
+// routes registers the handlers of the server.
+func (server *configServer) routes() *mux.Router {
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+
+	router.HandleFunc("/config/", countCreateConfig(server.createPostHandler)).Methods("POST")
+	router.HandleFunc("/configs/", countGetAll(server.getAllHandler)).Methods("GET")
+	router.HandleFunc("/configs/{id}", countConfigVersions(server.getConfigVersionsHandler)).Methods("GET")
+	router.HandleFunc("/configs/{id}/diff", countDiff(server.diffConfigHandler)).Methods("GET")
+	router.HandleFunc("/configs/{id}/{version}", countGetConfig(server.getConfigHandler)).Methods("GET")
+	router.HandleFunc("/config/{id}", countAddConfigVersion(server.addConfigVersion)).Methods("POST")
+	router.HandleFunc("/config/{id}", countDeleteAll(server.delAllConfigHandler)).Methods("DELETE")
+	router.HandleFunc("/config/{id}/{version}", countdelConfigVersion(server.delConfigHandler)).Methods("DELETE")
+	router.HandleFunc("/config/{id}/{version}", countPatchConfig(server.patchConfigHandler)).Methods("PATCH")
+	router.HandleFunc("/config/{id}/{version}", countPutConfig(server.putConfigHandler)).Methods("PUT")
+	router.HandleFunc("/config/{id}/{version}/restore", countRestore(server.restoreConfigHandler)).Methods("POST")
+	router.HandleFunc("/config/{id}/{version}/publish", countPublish(server.publishConfigHandler)).Methods("POST")
+	router.HandleFunc("/group/", counteCreateGroup(server.createGroupHandler)).Methods("POST")
+	router.HandleFunc("/group/", countegetAllGroup(server.getAllGroupHandler)).Methods("GET")
+	router.HandleFunc("/group/{id}/", counteAddGroupVersion(server.addConfigGroupVersion)).Methods("POST")
+	router.HandleFunc("/group/{id}/", counteGetConfigGroupVersions(server.getConfigGroupVersions)).Methods("GET")
+	router.HandleFunc("/group/{id}/", countDeleteAll(server.delAllGroupHandler)).Methods("DELETE")
+	router.HandleFunc("/group/{id}/diff/", countDiff(server.diffGroupHandler)).Methods("GET")
+	router.HandleFunc("/group/{id}/{version}/", filter(server.selectGroupHandler)).Methods("GET").Queries("selector", "{selector}")
+	router.HandleFunc("/group/{id}/{version}/", counteGetGroupVersion(server.getGroupVersionsHandler)).Methods("GET")
+	router.HandleFunc("/group/{id}/{version}/{labels}/", filter(server.filter)).Methods("GET")
+	router.HandleFunc("/group/{id}/{version}/", counteDelgroupHits(server.delGroupHandler)).Methods("DELETE")
+	router.HandleFunc("/group/{id}/{version}", counteAddConfigToGroup(server.addConfig)).Methods("PUT")
+	router.HandleFunc("/group/{id}/{version}/restore/", countRestore(server.restoreGroupHandler)).Methods("POST")
+	router.HandleFunc("/group/{id}/{version}/publish/", countPublish(server.publishGroupHandler)).Methods("POST")
+	router.HandleFunc("/retention/", countRetention(server.retentionReportHandler)).Methods("GET")
+	router.HandleFunc("/search/", countSearch(server.searchHandler)).Methods("GET")
+	router.HandleFunc("/trash/", countTrash(server.getTrashHandler)).Methods("GET")
+	router.HandleFunc("/trash/config/{id}/{version}/restore", countTrash(server.restoreTrashedConfigHandler)).Methods("POST")
+	router.HandleFunc("/trash/group/{id}/{version}/restore/", countTrash(server.restoreTrashedGroupHandler)).Methods("POST")
+	router.Path("/metrics").Handler(metricsHandler())
+	return router
+}
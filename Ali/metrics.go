@@ -86,11 +86,58 @@ var (
 			Name: "add_filter_hit_total",
 			Help: "Total number of filter hits",
 		})
+	diffHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "diff_hit_total",
+			Help: "Total number of diff hits",
+		})
+	patchConfigHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "patch_config_hit_total",
+			Help: "Total number of patch config hits",
+		})
+	restoreHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "restore_hit_total",
+			Help: "Total number of restore version hits",
+		})
+	putConfigHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "put_config_hit_total",
+			Help: "Total number of draft config update hits",
+		})
+	publishHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "publish_hit_total",
+			Help: "Total number of publish version hits",
+		})
+	retentionHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "retention_report_hit_total",
+			Help: "Total number of retention report hits",
+		})
+	trashHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "trash_hit_total",
+			Help: "Total number of trash hits",
+		})
+	deleteAllHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "delete_all_versions_hit_total",
+			Help: "Total number of delete all versions hits",
+		})
+	searchHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "search_hit_total",
+			Help: "Total number of entry search hits",
+		})
 	metricsList = []prometheus.Collector{
 		createConfigHits, getAllHits, getConfigVersionsHits, getConfigHits,
 		addConfigVersionHits, delConfigVersionHits, createGroupHits, getAllGroupHits,
 		addGroupVersionHits, getConfigGroupVersionsHits, getGroupVersionHits, delgroupHits,
-		addConfigToGroupHits, filterHits, httpHits,
+		addConfigToGroupHits, filterHits, httpHits, diffHits,
+		patchConfigHits, restoreHits, putConfigHits, publishHits,
+		retentionHits, trashHits, deleteAllHits, searchHits,
 	}
 	prometheusRegistry = prometheus.NewRegistry()
 )
@@ -199,3 +246,66 @@ func filter(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter
 		f(w, r) // original function call
 	}
 }
+func countDiff(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		diffHits.Inc()
+		f(w, r) // original function call
+	}
+}
+func countPatchConfig(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		patchConfigHits.Inc()
+		f(w, r) // original function call
+	}
+}
+func countRestore(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		restoreHits.Inc()
+		f(w, r) // original function call
+	}
+}
+func countPutConfig(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		putConfigHits.Inc()
+		f(w, r) // original function call
+	}
+}
+func countPublish(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		publishHits.Inc()
+		f(w, r) // original function call
+	}
+}
+func countRetention(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		retentionHits.Inc()
+		f(w, r) // original function call
+	}
+}
+func countTrash(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		trashHits.Inc()
+		f(w, r) // original function call
+	}
+}
+func countDeleteAll(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		deleteAllHits.Inc()
+		f(w, r) // original function call
+	}
+}
+func countSearch(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		searchHits.Inc()
+		f(w, r) // original function call
+	}
+}
@@ -1,5 +1,7 @@
 package main
 
+import cs "Ali/configstore"
+
 type Config struct {
 	Id      string            `json:"id"`
 	Version string            `json:"version"`
@@ -13,3 +15,16 @@ type Group struct {
 
 	//	Configs Config `json:"configs"`
 }
+
+type filterResult struct {
+	Count   int           `json:"count"`
+	Total   int           `json:"total"`
+	Next    string        `json:"next,omitempty"`
+	Configs []*cs.ConfigG `json:"configs"`
+}
+
+type deleteResult struct {
+	Deleted  string `json:"deleted"`
+	Versions int    `json:"versions"`
+	Error    string `json:"error,omitempty"`
+}
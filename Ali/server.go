@@ -9,24 +9,87 @@ import (
 	"github.com/opentracing/opentracing-go"
 	"golang.org/x/net/context"
 	"io"
+	"io/ioutil"
+	"log"
 	"mime"
 	"net/http"
-	"sort"
+	"os"
 	"strings"
+	"time"
 )
 
 const (
 	name = "config_service"
+
+	defaultIdempotencyTTL    = 24 * time.Hour
+	idempotencySweepInterval = 10 * time.Minute
+	idempotencyReservation   = time.Minute
+	defaultRetentionInterval = time.Hour
+	defaultTrashRetention    = 7 * 24 * time.Hour
+	trashPurgeInterval       = time.Hour
 )
 
 type configServer struct {
-	store  *cs.ConfigStore
-	tracer opentracing.Tracer
-	closer io.Closer
+	store          cs.Store
+	tracer         opentracing.Tracer
+	closer         io.Closer
+	idempotencyTTL time.Duration
+	retention      cs.RetentionPolicy
+	trashRetention time.Duration
+}
+
+func newStore() (cs.Store, error) {
+	if path := os.Getenv("DB_PATH"); path != "" {
+		return cs.NewBolt(path)
+	}
+	if os.Getenv("DB") == "" {
+		log.Println("DB is not set, using in-memory store")
+		return cs.NewInMemory(), nil
+	}
+	return cs.New()
+}
+
+// migrateStore brings the stored data up to the current schema version.
+func migrateStore(store cs.Store) error {
+	applied, err := store.Migrate(context.Background())
+	for _, name := range applied {
+		log.Println("applied migration:", name)
+	}
+	if err != nil {
+		return err
+	}
+	version, err := store.SchemaVersion(context.Background())
+	if err != nil {
+		return err
+	}
+	log.Println("store schema version", version)
+	return nil
 }
 
 func NewCOnfigServer() (*configServer, error) {
-	store, err := cs.New()
+	store, err := newStore()
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateStore(store); err != nil {
+		return nil, err
+	}
+
+	idempotencyTTL, err := durationEnv("IDEMPOTENCY_TTL", defaultIdempotencyTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	keepLast, err := intEnv("RETENTION_KEEP_LAST", 0)
+	if err != nil {
+		return nil, err
+	}
+	keepFor, err := durationEnv("RETENTION_KEEP_FOR", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	trashRetention, err := durationEnv("TRASH_RETENTION", defaultTrashRetention)
 	if err != nil {
 		return nil, err
 	}
@@ -34,11 +97,117 @@ func NewCOnfigServer() (*configServer, error) {
 	tracer, closer := tracer.Init(name)
 	opentracing.SetGlobalTracer(tracer)
 	return &configServer{
-		store:  store,
-		tracer: tracer,
-		closer: closer,
+		store:          store,
+		tracer:         tracer,
+		closer:         closer,
+		idempotencyTTL: idempotencyTTL,
+		retention:      cs.RetentionPolicy{KeepLast: keepLast, KeepFor: keepFor},
+		trashRetention: trashRetention,
 	}, nil
 }
+
+// idempotent runs handle once per idempotency key. The key is reserved
+// before handle runs, so a concurrent retry is answered with a conflict
+// instead of running handle again. The recorded response is stored with
+// the key and replayed as is when the request is retried with the same
+// payload, reusing the key for a different payload is rejected.
+func (c *configServer) idempotent(ctx context.Context, w http.ResponseWriter, req *http.Request, reqKey string, payload interface{}, handle func(w http.ResponseWriter)) {
+	span := tracer.StartSpanFromContext(ctx, "idempotent")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	fingerprint, err := requestFingerprint(req, payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pending := &cs.IdempotentResponse{Fingerprint: fingerprint, ExpiresAt: time.Now().Add(idempotencyReservation), Pending: true}
+	saved, err := c.store.ReserveId(ctx, reqKey, pending)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	if saved != nil {
+		if saved.Fingerprint != fingerprint {
+			http.Error(w, "idempotency-key was already used for a different request", http.StatusUnprocessableEntity)
+			return
+		}
+		if saved.Pending {
+			http.Error(w, "a request with this idempotency-key is still in progress", http.StatusConflict)
+			return
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		writeResponse(w, saved)
+		return
+	}
+
+	rec := newResponseRecorder()
+	handle(rec)
+	resp := rec.response()
+	resp.Fingerprint = fingerprint
+	resp.ExpiresAt = time.Now().Add(c.idempotencyTTL)
+	if resp.Status < http.StatusInternalServerError {
+		err = c.store.SaveId(ctx, reqKey, resp)
+	} else {
+		err = c.store.ReleaseId(ctx, reqKey)
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+	writeResponse(w, resp)
+}
+
+// sweepIdempotencyKeys periodically removes expired idempotency keys.
+func (cs *configServer) sweepIdempotencyKeys(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		removed, err := cs.store.SweepIds(context.Background(), time.Now())
+		if err != nil {
+			log.Println("idempotency sweep failed:", err)
+			continue
+		}
+		if removed > 0 {
+			log.Printf("removed %d expired idempotency keys\n", removed)
+		}
+	}
+}
+
+// pruneVersions periodically moves the versions the retention policy does
+// not keep to the trash.
+func (cs *configServer) pruneVersions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		report, err := cs.store.Prune(context.Background(), cs.retention, time.Now(), false)
+		if err != nil {
+			log.Println("version pruning failed:", err)
+			continue
+		}
+		if removed := len(report.Configs) + len(report.Groups); removed > 0 {
+			log.Printf("pruned %d config and %d group versions\n", len(report.Configs), len(report.Groups))
+		}
+	}
+}
+
+// purgeTrash periodically removes trashed versions older than the grace
+// period.
+func (cs *configServer) purgeTrash(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		removed, err := cs.store.PurgeTrash(context.Background(), time.Now().Add(-cs.trashRetention))
+		if err != nil {
+			log.Println("trash purge failed:", err)
+			continue
+		}
+		if removed > 0 {
+			log.Printf("purged %d trashed versions\n", removed)
+		}
+	}
+}
+
 func (c *configServer) GetTracer() opentracing.Tracer {
 	return c.tracer
 }
@@ -55,7 +224,7 @@ func (cs *configServer) createPostHandler(w http.ResponseWriter, req *http.Reque
 	span := tracer.StartSpanFromRequest("createConfigHandler", cs.tracer, req)
 	defer span.Finish()
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
 	span.LogFields(
 		tracer.LogString("Handler", fmt.Sprintf("Handling greate config at %s\n", req.URL.Path)),
 	)
@@ -79,25 +248,18 @@ func (cs *configServer) createPostHandler(w http.ResponseWriter, req *http.Reque
 		return
 	}
 	if reqKey == "" {
-		renderJSON(ctx, w, "Idempotency-key is missing")
+		http.Error(w, "Idempotency-key is missing", http.StatusBadRequest)
 		return
 	}
-	if cs.store.CheckId(ctx, reqKey) == true {
-		http.Error(w, "OK", http.StatusBadRequest)
-
-		return
-	}
-
-	idempotencyKey := ""
-	idempotencyKey = cs.store.SaveId(ctx)
-
-	post, err := cs.store.Post(ctx, rt)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	renderJSON(ctx, w, post)
-	w.Write([]byte(idempotencyKey))
+	cs.idempotent(ctx, w, req, reqKey, rt, func(w http.ResponseWriter) {
+		post, err := cs.store.Post(ctx, rt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		setETag(w, post.Index)
+		renderJSON(ctx, w, post)
+	})
 }
 func (cs *configServer) getAllHandler(w http.ResponseWriter, req *http.Request) {
 	span := tracer.StartSpanFromRequest("GetAllHandler", cs.tracer, req)
@@ -106,11 +268,17 @@ func (cs *configServer) getAllHandler(w http.ResponseWriter, req *http.Request)
 		tracer.LogString("handler", fmt.Sprintf("handling get all configs at %s\n", req.URL.Path)),
 	)
 	ctx := tracer.ContextWithSpan(context.Background(), span)
-	allTasks, err := cs.store.GetAll(ctx)
+	opts, err := listOptions(req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	allTasks, next, err := cs.store.GetAll(ctx, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	setNextPage(w, req, next)
 	renderJSON(ctx, w, allTasks)
 }
 func (cs *configServer) getAllGroupHandler(w http.ResponseWriter, req *http.Request) {
@@ -120,11 +288,17 @@ func (cs *configServer) getAllGroupHandler(w http.ResponseWriter, req *http.Requ
 		tracer.LogString("handler", fmt.Sprintf("handling get all groups at %s\n", req.URL.Path)),
 	)
 	ctx := tracer.ContextWithSpan(context.Background(), span)
-	allTasks, err := cs.store.GetAllGroups(ctx)
+	opts, err := listOptions(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	allTasks, next, err := cs.store.GetAllGroups(ctx, opts)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	setNextPage(w, req, next)
 	renderJSON(ctx, w, allTasks)
 }
 func (cs *configServer) addConfigVersion(w http.ResponseWriter, req *http.Request) {
@@ -133,7 +307,7 @@ func (cs *configServer) addConfigVersion(w http.ResponseWriter, req *http.Reques
 	span.LogFields(
 		tracer.LogString("handler", fmt.Sprintf("handling add config version at %s\n", req.URL.Path)),
 	)
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
 	contentType := req.Header.Get("Content-Type")
 	reqKey := req.Header.Get("idempotency-key")
 	mediatype, _, err := mime.ParseMediaType(contentType)
@@ -147,17 +321,9 @@ func (cs *configServer) addConfigVersion(w http.ResponseWriter, req *http.Reques
 		return
 	}
 	if reqKey == "" {
-		renderJSON(ctx, w, "Idempotency-key is missing")
+		http.Error(w, "Idempotency-key is missing", http.StatusBadRequest)
 		return
 	}
-	if cs.store.CheckId(ctx, reqKey) == true {
-		http.Error(w, "OK", http.StatusBadRequest)
-
-		return
-	}
-
-	idempotencyKey := ""
-	idempotencyKey = cs.store.SaveId(ctx)
 	rt, err := decodeBody(ctx, req.Body)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -165,13 +331,15 @@ func (cs *configServer) addConfigVersion(w http.ResponseWriter, req *http.Reques
 	}
 	id := mux.Vars(req)["id"]
 	rt.Id = id
-	config, err := cs.store.AddConfigVersion(ctx, rt)
-	if err != nil {
-		http.Error(w, "version already exist", http.StatusBadRequest)
-	}
-	renderJSON(ctx, w, config)
-	w.Write([]byte(idempotencyKey))
-
+	cs.idempotent(ctx, w, req, reqKey, rt, func(w http.ResponseWriter) {
+		config, err := cs.store.AddConfigVersion(ctx, rt)
+		if err != nil {
+			storeError(w, err)
+			return
+		}
+		setETag(w, config.Index)
+		renderJSON(ctx, w, config)
+	})
 }
 func (cs *configServer) getConfigHandler(w http.ResponseWriter, req *http.Request) {
 	span := tracer.StartSpanFromRequest("getConfigHandler", cs.tracer, req)
@@ -188,6 +356,7 @@ func (cs *configServer) getConfigHandler(w http.ResponseWriter, req *http.Reques
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	setETag(w, config.Index)
 	renderJSON(ctx, w, config)
 }
 func (cs *configServer) delConfigHandler(w http.ResponseWriter, req *http.Request) {
@@ -199,10 +368,13 @@ func (cs *configServer) delConfigHandler(w http.ResponseWriter, req *http.Reques
 	ctx := tracer.ContextWithSpan(context.Background(), span)
 	id := mux.Vars(req)["id"]
 	version := mux.Vars(req)["version"]
-	config, err := cs.store.Delete(ctx, id, version)
+	index, ok := ifMatch(w, req)
+	if !ok {
+		return
+	}
+	config, err := cs.store.Delete(ctx, id, version, index)
 	if err != nil {
-		err := errors.New("not found")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		storeError(w, err)
 		return
 	}
 	renderJSON(ctx, w, config)
@@ -217,6 +389,16 @@ func (cs *configServer) getConfigVersionsHandler(w http.ResponseWriter, req *htt
 		tracer.LogString("handler", fmt.Sprintf("handling get config version handler at %s\n", req.URL.Path)),
 	)
 	ctx := tracer.ContextWithSpan(context.Background(), span)
+	if versionRange := req.URL.Query().Get("version"); versionRange != "" {
+		config, err := cs.store.GetConf(ctx, id, versionRange)
+		if err != nil {
+			storeError(w, err)
+			return
+		}
+		setETag(w, config.Index)
+		renderJSON(ctx, w, config)
+		return
+	}
 	config, err := cs.store.GetConfVersions(ctx, id)
 	if err != nil {
 		err := errors.New("not found")
@@ -233,7 +415,7 @@ func (cs *configServer) createGroupHandler(w http.ResponseWriter, req *http.Requ
 	span.LogFields(
 		tracer.LogString("handler", fmt.Sprintf("handling create Group handler at %s\n", req.URL.Path)),
 	)
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
 	contentType := req.Header.Get("Content-Type")
 	reqKey := req.Header.Get("idempotency-key")
 	mediatype, _, err := mime.ParseMediaType(contentType)
@@ -254,24 +436,18 @@ func (cs *configServer) createGroupHandler(w http.ResponseWriter, req *http.Requ
 		return
 	}
 	if reqKey == "" {
-		renderJSON(ctx, w, "Idempotency-key is missing")
+		http.Error(w, "Idempotency-key is missing", http.StatusBadRequest)
 		return
 	}
-	if cs.store.CheckId(ctx, reqKey) == true {
-		http.Error(w, "OK", http.StatusBadRequest)
-
-		return
-	}
-
-	idempotencyKey := ""
-	idempotencyKey = cs.store.SaveId(ctx)
-	group, err := cs.store.Group(ctx, rt)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	renderJSON(ctx, w, group)
-	w.Write([]byte(idempotencyKey))
+	cs.idempotent(ctx, w, req, reqKey, rt, func(w http.ResponseWriter) {
+		group, err := cs.store.Group(ctx, rt)
+		if err != nil {
+			storeError(w, err)
+			return
+		}
+		setETag(w, group.Index)
+		renderJSON(ctx, w, group)
+	})
 }
 func (cs *configServer) addConfigGroupVersion(w http.ResponseWriter, req *http.Request) {
 	span := tracer.StartSpanFromRequest("addConfigVersion", cs.tracer, req)
@@ -279,7 +455,7 @@ func (cs *configServer) addConfigGroupVersion(w http.ResponseWriter, req *http.R
 	span.LogFields(
 		tracer.LogString("handler", fmt.Sprintf("handling add config version handler at %s\n", req.URL.Path)),
 	)
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
 	contentType := req.Header.Get("Content-Type")
 	reqKey := req.Header.Get("idempotency-key")
 
@@ -294,29 +470,25 @@ func (cs *configServer) addConfigGroupVersion(w http.ResponseWriter, req *http.R
 		return
 	}
 	if reqKey == "" {
-		renderJSON(ctx, w, "Idempotency-key is missing")
-		return
-	}
-	if cs.store.CheckId(ctx, reqKey) == true {
-		http.Error(w, "OK", http.StatusBadRequest)
+		http.Error(w, "Idempotency-key is missing", http.StatusBadRequest)
 		return
 	}
-
-	idempotencyKey := ""
-	idempotencyKey = cs.store.SaveId(ctx)
 	rt, err := decodeBodyGroups(ctx, req.Body)
 	if err != nil {
 		http.Error(w, "incvalid formtat", http.StatusBadRequest)
+		return
 	}
 	id := mux.Vars(req)["id"]
 	rt.Id = id
-	group, err := cs.store.AddConfigGroupVersion(ctx, rt)
-	if err != nil {
-		http.Error(w, "version alredy exitst", http.StatusBadRequest)
-	}
-	renderJSON(ctx, w, group)
-	w.Write([]byte(idempotencyKey))
-
+	cs.idempotent(ctx, w, req, reqKey, rt, func(w http.ResponseWriter) {
+		group, err := cs.store.AddConfigGroupVersion(ctx, rt)
+		if err != nil {
+			storeError(w, err)
+			return
+		}
+		setETag(w, group.Index)
+		renderJSON(ctx, w, group)
+	})
 }
 func (cs *configServer) delGroupHandler(w http.ResponseWriter, req *http.Request) {
 	span := tracer.StartSpanFromRequest("delGroupHandler", cs.tracer, req)
@@ -327,9 +499,13 @@ func (cs *configServer) delGroupHandler(w http.ResponseWriter, req *http.Request
 	ctx := tracer.ContextWithSpan(context.Background(), span)
 	id := mux.Vars(req)["id"]
 	version := mux.Vars(req)["version"]
-	group, err := cs.store.DeleteGroup(ctx, id, version)
+	index, ok := ifMatch(w, req)
+	if !ok {
+		return
+	}
+	group, err := cs.store.DeleteGroup(ctx, id, version, index)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		storeError(w, err)
 		return
 	}
 	renderJSON(ctx, w, group)
@@ -341,12 +517,9 @@ func (cs *configServer) addConfig(w http.ResponseWriter, req *http.Request) {
 	span.LogFields(
 		tracer.LogString("handler", fmt.Sprintf("handling add config to group at %s\n", req.URL.Path)),
 	)
-	ctx := tracer.ContextWithSpan(context.Background(), span)
-	id := mux.Vars(req)["id"]
-	version := mux.Vars(req)["version"]
-	_, err := cs.store.DeleteGroup(ctx, id, version)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
+	index, ok := ifMatch(w, req)
+	if !ok {
 		return
 	}
 
@@ -368,16 +541,18 @@ func (cs *configServer) addConfig(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	id2 := mux.Vars(req)["id"]
-	version2 := mux.Vars(req)["version"]
-	rt.Id = id2
-	rt.Version = version2
+	id := mux.Vars(req)["id"]
+	version := mux.Vars(req)["version"]
+	rt.Id = id
+	rt.Version = version
+	rt.Index = index
 
 	nova, err := cs.store.Put(ctx, rt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		storeError(w, err)
 		return
 	}
+	setETag(w, nova.Index)
 	renderJSON(ctx, w, nova)
 }
 func (cs *configServer) getGroupVersionsHandler(w http.ResponseWriter, req *http.Request) {
@@ -395,6 +570,7 @@ func (cs *configServer) getGroupVersionsHandler(w http.ResponseWriter, req *http
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	setETag(w, group.Index)
 	renderJSON(ctx, w, group)
 }
 func (cs *configServer) getConfigGroupVersions(w http.ResponseWriter, req *http.Request) {
@@ -405,6 +581,16 @@ func (cs *configServer) getConfigGroupVersions(w http.ResponseWriter, req *http.
 	)
 	ctx := tracer.ContextWithSpan(context.Background(), span)
 	id := mux.Vars(req)["id"]
+	if versionRange := req.URL.Query().Get("version"); versionRange != "" {
+		group, err := cs.store.GetGroup(ctx, id, versionRange)
+		if err != nil {
+			storeError(w, err)
+			return
+		}
+		setETag(w, group.Index)
+		renderJSON(ctx, w, group)
+		return
+	}
 	group, err := cs.store.GetConfGroupVersions(ctx, id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -423,45 +609,368 @@ func (cs *configServer) filter(w http.ResponseWriter, req *http.Request) {
 	id := mux.Vars(req)["id"]
 	version := mux.Vars(req)["version"]
 	labels := mux.Vars(req)["labels"]
-	group, err := cs.store.GetGroup(ctx, id, version)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
 	entries := strings.Split(labels, ",")
-	//  https://stackoverflow.com/questions/21362950/getting-a-slice-of-keys-from-a-map
 	m := make(map[string]string)
 	for _, e := range entries {
-		parts := strings.Split(e, ":")
+		parts := strings.SplitN(e, ":", 2)
+		if len(parts) != 2 {
+			http.Error(w, fmt.Sprintf("invalid label %q, expected key:value", e), http.StatusBadRequest)
+			return
+		}
 		m[parts[0]] = parts[1]
 	}
 
-	for i := 0; i < len(group.Config); i++ {
-		entries := group.Config[i].Entries
-		if len(m) == len(group.Config[i].Entries) {
-			check := false
-			key := make([]string, 0, len(entries))
-			for k := range entries {
-				key = append(key, k)
-			}
-
-			sort.Strings(key)
-			for _, k := range key {
-				i, ok := m[k]
-				if ok == false {
-					check = true
-					break
-				} else {
-					if i != entries[k] {
-						check = true
-						break
-					}
-				}
-
-			}
-			if check != true {
-				renderJSON(ctx, w, group.Config[i])
-			}
+	configs, err := cs.store.FilterGroup(ctx, id, version, m)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	renderFilterResult(ctx, w, req, configs)
+}
+
+func (cs *configServer) selectGroupHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("selectGroupHandler", cs.tracer, req)
+	defer span.Finish()
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling select group configs at %s\n", req.URL.Path)),
+	)
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+	id := mux.Vars(req)["id"]
+	version := mux.Vars(req)["version"]
+	sel, err := decodeSelector(ctx, req.URL.Query().Get("selector"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	configs, err := cs.store.SelectGroup(ctx, id, version, sel)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	renderFilterResult(ctx, w, req, configs)
+}
+
+func (cs *configServer) diffConfigHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("diffConfigHandler", cs.tracer, req)
+	defer span.Finish()
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling diff config versions at %s\n", req.URL.Path)),
+	)
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+	id := mux.Vars(req)["id"]
+	from, to, ok := diffVersions(w, req)
+	if !ok {
+		return
+	}
+	diff, err := cs.store.DiffConfig(ctx, id, from, to)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	if req.URL.Query().Get("format") == "json-patch" {
+		renderJSONPatch(ctx, w, diff.Patch())
+		return
+	}
+	renderJSON(ctx, w, diff)
+}
+
+func (cs *configServer) diffGroupHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("diffGroupHandler", cs.tracer, req)
+	defer span.Finish()
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling diff group versions at %s\n", req.URL.Path)),
+	)
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+	id := mux.Vars(req)["id"]
+	from, to, ok := diffVersions(w, req)
+	if !ok {
+		return
+	}
+	diff, err := cs.store.DiffGroup(ctx, id, from, to)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	if req.URL.Query().Get("format") == "json-patch" {
+		renderJSONPatch(ctx, w, diff.Patch())
+		return
+	}
+	renderJSON(ctx, w, diff)
+}
+
+func (cs *configServer) patchConfigHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("patchConfigHandler", cs.tracer, req)
+	defer span.Finish()
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling patch config at %s\n", req.URL.Path)),
+	)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
+	contentType := req.Header.Get("Content-Type")
+	reqKey := req.Header.Get("idempotency-key")
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !isPatchType(mediatype) {
+		err := errors.New("expect application/json-patch+json or application/merge-patch+json Content-Type")
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if reqKey == "" {
+		http.Error(w, "Idempotency-key is missing", http.StatusBadRequest)
+		return
+	}
+	patch, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := mux.Vars(req)["id"]
+	base := mux.Vars(req)["version"]
+	version := req.URL.Query().Get("version")
+	payload := map[string]string{"type": mediatype, "version": version, "patch": string(patch)}
+	cs.idempotent(ctx, w, req, reqKey, payload, func(w http.ResponseWriter) {
+		config, err := cs.store.PatchConfig(ctx, id, base, version, mediatype, patch)
+		if err != nil {
+			storeError(w, err)
+			return
+		}
+		setETag(w, config.Index)
+		renderJSON(ctx, w, config)
+	})
+}
+
+func (cs *configServer) restoreConfigHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("restoreConfigHandler", cs.tracer, req)
+	defer span.Finish()
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling restore config version at %s\n", req.URL.Path)),
+	)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
+	reqKey := req.Header.Get("idempotency-key")
+	if reqKey == "" {
+		http.Error(w, "Idempotency-key is missing", http.StatusBadRequest)
+		return
+	}
+	id := mux.Vars(req)["id"]
+	version := mux.Vars(req)["version"]
+	as := req.URL.Query().Get("as")
+	cs.idempotent(ctx, w, req, reqKey, map[string]string{"as": as}, func(w http.ResponseWriter) {
+		config, err := cs.store.RestoreConfig(ctx, id, version, as)
+		if err != nil {
+			storeError(w, err)
+			return
+		}
+		setETag(w, config.Index)
+		renderJSON(ctx, w, config)
+	})
+}
+
+func (cs *configServer) restoreGroupHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("restoreGroupHandler", cs.tracer, req)
+	defer span.Finish()
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling restore group version at %s\n", req.URL.Path)),
+	)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
+	reqKey := req.Header.Get("idempotency-key")
+	if reqKey == "" {
+		http.Error(w, "Idempotency-key is missing", http.StatusBadRequest)
+		return
+	}
+	id := mux.Vars(req)["id"]
+	version := mux.Vars(req)["version"]
+	as := req.URL.Query().Get("as")
+	cs.idempotent(ctx, w, req, reqKey, map[string]string{"as": as}, func(w http.ResponseWriter) {
+		group, err := cs.store.RestoreGroup(ctx, id, version, as)
+		if err != nil {
+			storeError(w, err)
+			return
 		}
+		setETag(w, group.Index)
+		renderJSON(ctx, w, group)
+	})
+}
+func (cs *configServer) putConfigHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("putConfigHandler", cs.tracer, req)
+	defer span.Finish()
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling put config at %s\n", req.URL.Path)),
+	)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
+	index, ok := ifMatch(w, req)
+	if !ok {
+		return
+	}
+
+	contentType := req.Header.Get("Content-Type")
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if mediatype != "application/json" {
+		err := errors.New("expect application/json Content-Type")
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	rt, err := decodeBody(ctx, req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rt.Id = mux.Vars(req)["id"]
+	rt.Version = mux.Vars(req)["version"]
+	rt.Index = index
+
+	config, err := cs.store.PutConfig(ctx, rt)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	setETag(w, config.Index)
+	renderJSON(ctx, w, config)
+}
+func (cs *configServer) publishConfigHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("publishConfigHandler", cs.tracer, req)
+	defer span.Finish()
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling publish config at %s\n", req.URL.Path)),
+	)
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+	index, ok := ifMatch(w, req)
+	if !ok {
+		return
+	}
+	config, err := cs.store.PublishConfig(ctx, mux.Vars(req)["id"], mux.Vars(req)["version"], index)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	setETag(w, config.Index)
+	renderJSON(ctx, w, config)
+}
+func (cs *configServer) publishGroupHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("publishGroupHandler", cs.tracer, req)
+	defer span.Finish()
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling publish group at %s\n", req.URL.Path)),
+	)
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+	index, ok := ifMatch(w, req)
+	if !ok {
+		return
+	}
+	group, err := cs.store.PublishGroup(ctx, mux.Vars(req)["id"], mux.Vars(req)["version"], index)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	setETag(w, group.Index)
+	renderJSON(ctx, w, group)
+}
+func (cs *configServer) retentionReportHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("retentionReportHandler", cs.tracer, req)
+	defer span.Finish()
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling retention report at %s\n", req.URL.Path)),
+	)
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+	report, err := cs.store.Prune(ctx, cs.retention, time.Now(), true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderJSON(ctx, w, report)
+}
+func (cs *configServer) getTrashHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getTrashHandler", cs.tracer, req)
+	defer span.Finish()
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling get trash at %s\n", req.URL.Path)),
+	)
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+	items, err := cs.store.ListTrash(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderJSON(ctx, w, items)
+}
+func (cs *configServer) restoreTrashedConfigHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("restoreTrashedConfigHandler", cs.tracer, req)
+	defer span.Finish()
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling restore trashed config at %s\n", req.URL.Path)),
+	)
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+	config, err := cs.store.RestoreTrashedConfig(ctx, mux.Vars(req)["id"], mux.Vars(req)["version"])
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	setETag(w, config.Index)
+	renderJSON(ctx, w, config)
+}
+func (cs *configServer) restoreTrashedGroupHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("restoreTrashedGroupHandler", cs.tracer, req)
+	defer span.Finish()
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling restore trashed group at %s\n", req.URL.Path)),
+	)
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+	group, err := cs.store.RestoreTrashedGroup(ctx, mux.Vars(req)["id"], mux.Vars(req)["version"])
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	setETag(w, group.Index)
+	renderJSON(ctx, w, group)
+}
+func (cs *configServer) delAllConfigHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("delAllConfigHandler", cs.tracer, req)
+	defer span.Finish()
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling delete all config versions at %s\n", req.URL.Path)),
+	)
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+	id := mux.Vars(req)["id"]
+	if !confirmed(w, req, id) {
+		return
+	}
+	removed, err := cs.store.DeleteConfigTree(ctx, id)
+	renderDeleteResult(ctx, w, id, removed, err)
+}
+func (cs *configServer) delAllGroupHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("delAllGroupHandler", cs.tracer, req)
+	defer span.Finish()
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling delete all group versions at %s\n", req.URL.Path)),
+	)
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+	id := mux.Vars(req)["id"]
+	if !confirmed(w, req, id) {
+		return
+	}
+	removed, err := cs.store.DeleteGroupTree(ctx, id)
+	renderDeleteResult(ctx, w, id, removed, err)
+}
+func (c *configServer) searchHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("searchHandler", c.tracer, req)
+	defer span.Finish()
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling search at %s\n", req.URL.Path)),
+	)
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+	query := req.URL.Query()
+	hits, err := c.store.SearchEntries(ctx, cs.EntryQuery{Key: query.Get("key"), Value: query.Get("value"), Match: query.Get("match")})
+	if err != nil {
+		storeError(w, err)
+		return
 	}
+	renderJSON(ctx, w, hits)
 }
@@ -0,0 +1,532 @@
+package main
+
+import (
+	cs "Ali/configstore"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/opentracing/opentracing-go"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testServer serves the routes of a config server backed by the in-memory
+// store.
+type testServer struct {
+	t      *testing.T
+	router http.Handler
+	keys   int
+}
+
+func newTestServer(t *testing.T) *testServer {
+	server := &configServer{
+		store:          cs.NewInMemory(),
+		tracer:         opentracing.NoopTracer{},
+		idempotencyTTL: time.Hour,
+		trashRetention: time.Hour,
+	}
+	return &testServer{t: t, router: server.routes()}
+}
+
+// do sends a request with the given header name and value pairs. A string
+// body is sent as is, anything else as JSON.
+func (s *testServer) do(method string, path string, body interface{}, header ...string) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var data []byte
+	switch b := body.(type) {
+	case nil:
+	case string:
+		data = []byte(b)
+	default:
+		var err error
+		if data, err = json.Marshal(b); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// create sends a JSON write with a new idempotency key and decodes the
+// response into v when it succeeds.
+func (s *testServer) create(method string, path string, body interface{}, v interface{}) *httptest.ResponseRecorder {
+	s.t.Helper()
+	s.keys++
+	w := s.do(method, path, body, "Content-Type", "application/json", "Idempotency-key", "key-"+strconv.Itoa(s.keys))
+	if w.Code == http.StatusOK && v != nil {
+		decodeResponse(s.t, w, v)
+	}
+	return w
+}
+
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("%s: %v", w.Body, err)
+	}
+}
+
+func TestConfigHandlers(t *testing.T) {
+	s := newTestServer(t)
+	config := &cs.Config{}
+	if w := s.create("POST", "/config/", &cs.Config{Version: "1.0.0", Entries: map[string]string{"a": "1"}}, config); w.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	if w := s.create("POST", "/config/"+config.Id, &cs.Config{Version: "1.1.0", Entries: map[string]string{"a": "2"}}, nil); w.Code != http.StatusOK {
+		t.Fatalf("add version: %d %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+		want   string
+	}{
+		{"/configs/" + config.Id + "/1.0.0", http.StatusOK, "1"},
+		{"/configs/" + config.Id + "/latest", http.StatusOK, "2"},
+		{"/configs/" + config.Id + "?version=^1.0", http.StatusOK, "2"},
+		{"/configs/" + config.Id + "/2.0.0", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := s.do("GET", tt.path, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			got := &cs.Config{}
+			decodeResponse(t, w, got)
+			if got.Entries["a"] != tt.want || w.Header().Get("ETag") == "" {
+				t.Errorf("got a=%s with ETag %q, want a=%s", got.Entries["a"], w.Header().Get("ETag"), tt.want)
+			}
+		})
+	}
+
+	configs := []*cs.Config{}
+	w := s.do("GET", "/configs/?limit=1", nil)
+	decodeResponse(t, w, &configs)
+	if len(configs) != 1 || w.Header().Get("X-Next-Cursor") == "" {
+		t.Errorf("first page of %d configs with cursor %q", len(configs), w.Header().Get("X-Next-Cursor"))
+	}
+}
+
+func TestGroupHandlers(t *testing.T) {
+	s := newTestServer(t)
+	group := &cs.Group{}
+	body := &cs.Group{Version: "1.0.0", Config: []*cs.ConfigG{{Entries: map[string]string{"a": "1"}}}}
+	if w := s.create("POST", "/group/", body, group); w.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	if w := s.create("POST", "/group/", &cs.Group{Version: "1.0.0"}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("group without configs: %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	got := &cs.Group{}
+	w := s.do("GET", "/group/"+group.Id+"/1.0.0/", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("get: %d %s", w.Code, w.Body)
+	}
+	decodeResponse(t, w, got)
+	if len(got.Config) != 1 || got.Config[0].Entries["a"] != "1" {
+		t.Errorf("got %+v", got.Config)
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	s := newTestServer(t)
+	config := &cs.Config{}
+	w := s.create("POST", "/config/", &cs.Config{Version: "1.0.0", Entries: map[string]string{}}, config)
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag on create")
+	}
+	index, err := strconv.ParseUint(etag[1:len(etag)-1], 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := "\"" + strconv.FormatUint(index+100, 10) + "\""
+	tests := []struct {
+		name    string
+		ifMatch []string
+		status  int
+	}{
+		{"missing", nil, http.StatusPreconditionRequired},
+		{"malformed", []string{"If-Match", "\"abc\""}, http.StatusBadRequest},
+		{"stale", []string{"If-Match", stale}, http.StatusPreconditionFailed},
+		{"current", []string{"If-Match", etag}, http.StatusOK},
+		{"already deleted", []string{"If-Match", etag}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := s.do("DELETE", "/config/"+config.Id+"/1.0.0", nil, tt.ifMatch...); w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestCreateExistingVersion(t *testing.T) {
+	s := newTestServer(t)
+	config := &cs.Config{}
+	s.create("POST", "/config/", &cs.Config{Version: "1.0.0", Entries: map[string]string{}}, config)
+	group := &cs.Group{}
+	s.create("POST", "/group/", &cs.Group{Version: "1.0.0", Config: []*cs.ConfigG{}}, group)
+	tests := []struct {
+		path   string
+		body   interface{}
+		status int
+	}{
+		{"/config/" + config.Id, &cs.Config{Version: "1.0.0", Entries: map[string]string{"a": "1"}}, http.StatusConflict},
+		{"/config/" + config.Id, &cs.Config{Version: "1.0.1", Entries: map[string]string{"a": "1"}}, http.StatusOK},
+		{"/group/" + group.Id + "/", &cs.Group{Version: "1.0.0", Config: []*cs.ConfigG{}}, http.StatusConflict},
+		{"/group/" + group.Id + "/", &cs.Group{Version: "1.0.1", Config: []*cs.ConfigG{}}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := s.create("POST", tt.path, tt.body, nil); w.Code != tt.status {
+			t.Errorf("POST %s: status %d, want %d: %s", tt.path, w.Code, tt.status, w.Body)
+		}
+	}
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	s := newTestServer(t)
+	body := &cs.Config{Version: "1.0.0", Entries: map[string]string{"a": "1"}}
+	headers := []string{"Content-Type", "application/json", "Idempotency-key", "retry"}
+	first := s.do("POST", "/config/", body, headers...)
+	if first.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", first.Code, first.Body)
+	}
+	retry := s.do("POST", "/config/", body, headers...)
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() || retry.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Errorf("retry answered %d %s, want the first response %d %s", retry.Code, retry.Body, first.Code, first.Body)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("retry not marked as replayed")
+	}
+	configs := []*cs.Config{}
+	decodeResponse(t, s.do("GET", "/configs/", nil), &configs)
+	if len(configs) != 1 {
+		t.Errorf("retry stored %d configs, want 1", len(configs))
+	}
+
+	failed := []string{"Content-Type", "application/json", "Idempotency-key", "failed"}
+	invalid := &cs.Config{Version: "1", Entries: map[string]string{}}
+	if w := s.do("POST", "/config/", invalid, failed...); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid version: %d %s", w.Code, w.Body)
+	}
+	if w := s.do("POST", "/config/", invalid, failed...); w.Code != http.StatusBadRequest || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry of a rejected request: %d, replayed %q", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+}
+
+func TestIdempotencyKeyReuse(t *testing.T) {
+	s := newTestServer(t)
+	long := string(bytes.Repeat([]byte("k"), cs.MaxIdempotencyKeyLength+1))
+	tests := []struct {
+		name   string
+		key    string
+		body   *cs.Config
+		status int
+	}{
+		{"first use", "reused", &cs.Config{Version: "1.0.0", Entries: map[string]string{"a": "1"}}, http.StatusOK},
+		{"other payload", "reused", &cs.Config{Version: "1.0.0", Entries: map[string]string{"a": "2"}}, http.StatusUnprocessableEntity},
+		{"key with slashes", "a/b/../c", &cs.Config{Version: "1.0.0", Entries: map[string]string{}}, http.StatusOK},
+		{"key too long", long, &cs.Config{Version: "1.0.0", Entries: map[string]string{}}, http.StatusBadRequest},
+		{"missing key", "", &cs.Config{Version: "1.0.0", Entries: map[string]string{}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do("POST", "/config/", tt.body, "Content-Type", "application/json", "Idempotency-key", tt.key)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+	if w := s.do("POST", "/config/other", &cs.Config{Version: "1.0.0", Entries: map[string]string{"a": "1"}}, "Content-Type", "application/json", "Idempotency-key", "reused"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("same payload on another path: %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+// seedFilterGroup stores a group version with four configs to filter and
+// returns the path of the version.
+func seedFilterGroup(s *testServer) string {
+	s.t.Helper()
+	group := &cs.Group{}
+	s.create("POST", "/group/", &cs.Group{Version: "1.0.0", Config: []*cs.ConfigG{
+		{Entries: map[string]string{"env": "dev", "tier": "web"}},
+		{Entries: map[string]string{"env": "prod", "tier": "web"}},
+		{Entries: map[string]string{"env": "prod", "tier": "db"}},
+		{Entries: map[string]string{"env": "prod", "tier": "web", "zone": "a b"}},
+	}}, group)
+	return "/group/" + group.Id + "/1.0.0/"
+}
+
+// filterTest is a filter request and the status and number of configs it
+// is answered with.
+type filterTest struct {
+	name   string
+	path   string
+	status int
+	count  int
+}
+
+func runFilterTests(t *testing.T, s *testServer, tests []filterTest) {
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do("GET", tt.path, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			result := &filterResult{}
+			decodeResponse(t, w, result)
+			if result.Count != tt.count || len(result.Configs) != tt.count {
+				t.Errorf("got %d configs, want %d: %s", len(result.Configs), tt.count, w.Body)
+			}
+		})
+	}
+}
+
+func TestFilterGroup(t *testing.T) {
+	s := newTestServer(t)
+	base := seedFilterGroup(s)
+	runFilterTests(t, s, []filterTest{
+		{"labels", base + "env:prod,tier:web/", http.StatusOK, 1},
+		{"label with a space", base + "env:prod,tier:web,zone:a%20b/", http.StatusOK, 1},
+		{"no match", base + "env:test/", http.StatusOK, 0},
+		{"malformed labels", base + "env/", http.StatusBadRequest, 0},
+	})
+}
+
+func TestSelectGroup(t *testing.T) {
+	s := newTestServer(t)
+	base := seedFilterGroup(s)
+	runFilterTests(t, s, []filterTest{
+		{"equality", base + "?selector=env%3Dprod", http.StatusOK, 3},
+		{"set and inequality", base + "?selector=tier+in+(web),env!%3Ddev", http.StatusOK, 2},
+		{"existence", base + "?selector=zone", http.StatusOK, 1},
+		{"malformed", base + "?selector=%3Dprod", http.StatusBadRequest, 0},
+	})
+}
+
+func TestFilterResultDocument(t *testing.T) {
+	s := newTestServer(t)
+	base := seedFilterGroup(s)
+	runFilterTests(t, s, []filterTest{
+		{"paged labels", base + "env:prod,tier:web/?limit=1", http.StatusOK, 1},
+		{"paged selector", base + "?selector=env%3Dprod&limit=2", http.StatusOK, 2},
+		{"limit above maximum", base + "?selector=env%3Dprod&limit=5000", http.StatusBadRequest, 0},
+		{"missing group", "/group/missing/1.0.0/env:prod/", http.StatusNotFound, 0},
+		{"missing version", base[:len(base)-len("1.0.0/")] + "2.0.0/?selector=env%3Dprod", http.StatusNotFound, 0},
+	})
+}
+
+func TestRestoreHandlers(t *testing.T) {
+	s := newTestServer(t)
+	config := &cs.Config{}
+	s.create("POST", "/config/", &cs.Config{Version: "1.0.0", Entries: map[string]string{"a": "1"}}, config)
+	s.create("POST", "/config/"+config.Id, &cs.Config{Version: "1.1.0", Entries: map[string]string{"a": "2"}}, nil)
+	group := &cs.Group{}
+	s.create("POST", "/group/", &cs.Group{Version: "1.0.0", Config: []*cs.ConfigG{{Entries: map[string]string{"a": "1"}}}}, group)
+
+	tests := []struct {
+		name    string
+		path    string
+		status  int
+		version string
+	}{
+		{"config to next patch", "/config/" + config.Id + "/1.0.0/restore", http.StatusOK, "1.1.1"},
+		{"config as version", "/config/" + config.Id + "/1.0.0/restore?as=2.0.0", http.StatusOK, "2.0.0"},
+		{"config as existing version", "/config/" + config.Id + "/1.0.0/restore?as=1.1.0", http.StatusConflict, ""},
+		{"config as invalid version", "/config/" + config.Id + "/1.0.0/restore?as=2", http.StatusBadRequest, ""},
+		{"missing config", "/config/missing/1.0.0/restore", http.StatusNotFound, ""},
+		{"group to next patch", "/group/" + group.Id + "/1.0.0/restore/", http.StatusOK, "1.0.1"},
+		{"missing group version", "/group/" + group.Id + "/3.0.0/restore/", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restored := &cs.Config{}
+			w := s.create("POST", tt.path, nil, restored)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if restored.Version != tt.version || restored.Meta == nil || restored.Meta.Parent != "1.0.0" || restored.DerivedFrom != "1.0.0" {
+				t.Errorf("restored %s from %+v, want %s from 1.0.0", restored.Version, restored.Meta, tt.version)
+			}
+		})
+	}
+
+	got := &cs.Config{}
+	decodeResponse(t, s.do("GET", "/configs/"+config.Id+"/2.0.0", nil), got)
+	if got.Entries["a"] != "1" {
+		t.Errorf("restored entries %v, want those of 1.0.0", got.Entries)
+	}
+	if w := s.do("POST", "/config/"+config.Id+"/1.0.0/restore", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("restore without an idempotency key: %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestChangeMetadata(t *testing.T) {
+	s := newTestServer(t)
+	change := []string{
+		"Content-Type", "application/json",
+		"Idempotency-key", "meta",
+		"X-User", "alice",
+		"X-Change-Description", "raise the limit",
+		"X-Version-Tags", " stable, ,release ",
+	}
+	w := s.do("POST", "/config/", &cs.Config{Version: "1.0.0", Entries: map[string]string{"a": "1"}}, change...)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	config := &cs.Config{}
+	decodeResponse(t, w, config)
+	s.create("POST", "/config/"+config.Id+"/1.0.0/restore", nil, nil)
+
+	tests := []struct {
+		version     string
+		createdBy   string
+		description string
+		tags        string
+		parent      string
+	}{
+		{"1.0.0", "alice", "raise the limit", "[stable release]", ""},
+		{"1.0.1", "", "", "[]", "1.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got := &cs.Config{}
+			decodeResponse(t, s.do("GET", "/configs/"+config.Id+"/"+tt.version, nil), got)
+			meta := got.Meta
+			if meta == nil {
+				t.Fatal("no metadata")
+			}
+			if meta.CreatedBy != tt.createdBy || meta.Description != tt.description || fmt.Sprint(meta.Tags) != tt.tags || meta.Parent != tt.parent {
+				t.Errorf("got %+v", meta)
+			}
+			if meta.CreatedAt.IsZero() || meta.Hash == "" {
+				t.Errorf("missing creation time or hash: %+v", meta)
+			}
+		})
+	}
+
+	spoofed := &cs.Config{Version: "1.1.0", Entries: map[string]string{}, Meta: &cs.Metadata{CreatedBy: "mallory"}, DerivedFrom: "0.0.1"}
+	added := &cs.Config{}
+	s.create("POST", "/config/"+config.Id, spoofed, added)
+	if added.Meta == nil || added.Meta.CreatedBy != "" || added.Meta.Parent != "1.0.1" || added.DerivedFrom != "1.0.1" {
+		t.Errorf("got %+v derived from %q, want metadata of the request derived from the latest version", added.Meta, added.DerivedFrom)
+	}
+}
+
+func TestDraftAndPublish(t *testing.T) {
+	s := newTestServer(t)
+	config := &cs.Config{}
+	w := s.create("POST", "/config/", &cs.Config{Version: "1.0.0", State: cs.DraftState, Entries: map[string]string{"a": "1"}}, config)
+	if w.Code != http.StatusOK || config.State != cs.DraftState {
+		t.Fatalf("create draft: %d %s", w.Code, w.Body)
+	}
+	if w := s.create("POST", "/config/"+config.Id, &cs.Config{Version: "1.1.0", State: "final", Entries: map[string]string{}}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown state: %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	path := "/config/" + config.Id + "/1.0.0"
+	etag := w.Header().Get("ETag")
+	put := func(etag string, a string) *httptest.ResponseRecorder {
+		return s.do("PUT", path, &cs.Config{Entries: map[string]string{"a": a}}, "Content-Type", "application/json", "If-Match", etag)
+	}
+
+	w = put(etag, "2")
+	if w.Code != http.StatusOK {
+		t.Fatalf("put draft: %d %s", w.Code, w.Body)
+	}
+	if put(etag, "3").Code != http.StatusPreconditionFailed {
+		t.Error("put at a stale ETag succeeded")
+	}
+	etag = w.Header().Get("ETag")
+	if w := s.do("POST", path+"/publish", nil); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("publish without If-Match: %d, want %d", w.Code, http.StatusPreconditionRequired)
+	}
+	w = s.do("POST", path+"/publish", nil, "If-Match", etag)
+	published := &cs.Config{}
+	decodeResponse(t, w, published)
+	if w.Code != http.StatusOK || published.State != cs.PublishedState || published.Entries["a"] != "2" {
+		t.Fatalf("publish: %d %s", w.Code, w.Body)
+	}
+	if w := put(w.Header().Get("ETag"), "4"); w.Code != http.StatusConflict {
+		t.Errorf("put published: %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	group := &cs.Group{}
+	w = s.create("POST", "/group/", &cs.Group{Version: "1.0.0", State: cs.DraftState, Config: []*cs.ConfigG{}}, group)
+	if w.Code != http.StatusOK || group.State != cs.DraftState {
+		t.Fatalf("create draft group: %d %s", w.Code, w.Body)
+	}
+	w = s.do("POST", "/group/"+group.Id+"/1.0.0/publish/", nil, "If-Match", w.Header().Get("ETag"))
+	decodeResponse(t, w, group)
+	if w.Code != http.StatusOK || group.State != cs.PublishedState {
+		t.Errorf("publish group: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestDeleteAll(t *testing.T) {
+	s := newTestServer(t)
+	config := &cs.Config{}
+	s.create("POST", "/config/", &cs.Config{Version: "1.0.0", Entries: map[string]string{}}, config)
+	s.create("POST", "/config/"+config.Id, &cs.Config{Version: "1.1.0", Entries: map[string]string{}}, nil)
+	used := &cs.Config{}
+	s.create("POST", "/config/", &cs.Config{Version: "1.0.0", Entries: map[string]string{}}, used)
+	group := &cs.Group{}
+	s.create("POST", "/group/", &cs.Group{Version: "1.0.0", Config: []*cs.ConfigG{{Ref: &cs.ConfigRef{Id: used.Id, Version: "1.0.0"}}}}, group)
+	s.create("POST", "/group/"+group.Id+"/", &cs.Group{Version: "1.1.0", Config: []*cs.ConfigG{}}, nil)
+
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		versions int
+	}{
+		{"unconfirmed", "/config/" + config.Id, http.StatusBadRequest, 0},
+		{"confirmed for another id", "/config/" + config.Id + "?confirm=" + used.Id, http.StatusBadRequest, 0},
+		{"config", "/config/" + config.Id + "?confirm=" + config.Id, http.StatusOK, 2},
+		{"deleted config", "/config/" + config.Id + "?confirm=" + config.Id, http.StatusNotFound, 0},
+		{"missing config", "/config/missing?confirm=missing", http.StatusNotFound, 0},
+		{"referenced config", "/config/" + used.Id + "?confirm=" + used.Id, http.StatusConflict, 0},
+		{"unconfirmed group", "/group/" + group.Id + "/", http.StatusBadRequest, 0},
+		{"group", "/group/" + group.Id + "/?confirm=" + group.Id, http.StatusOK, 2},
+		{"config no longer referenced", "/config/" + used.Id + "?confirm=" + used.Id, http.StatusOK, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do("DELETE", tt.path, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			result := &deleteResult{}
+			decodeResponse(t, w, result)
+			if result.Versions != tt.versions {
+				t.Errorf("deleted %d versions, want %d", result.Versions, tt.versions)
+			}
+		})
+	}
+
+	trashed := s.do("POST", "/trash/config/"+config.Id+"/1.1.0/restore", nil, "Idempotency-key", "undo")
+	if trashed.Code != http.StatusOK {
+		t.Errorf("restore from the trash: %d %s", trashed.Code, trashed.Body)
+	}
+}